@@ -0,0 +1,1504 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"math/rand"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// SortedMap is the API shared by OrderedMap and BTreeMap, so either can be
+// used behind it. Each map also has an iterator and bulk operations that
+// take and return its own type, serialization is only provided by OrderedMap
+type SortedMap[K, V any] interface {
+	Insert(key K, value V)
+	Upsert(key K, value V) (V, bool)
+	Erase(key K)
+	Contains(key K) bool
+	Get(key K) (V, bool)
+	GetOrDefault(key K, defaultValue V) V
+	Size() int
+
+	ForEach(action func(K, V))
+	ForEachDescending(action func(K, V))
+	Range(lo, hi K, action func(K, V) bool)
+	RangeDescending(lo, hi K, action func(K, V) bool)
+
+	Min() (K, V, bool)
+	Max() (K, V, bool)
+	Floor(key K) (K, V, bool)
+	Lower(key K) (K, V, bool)
+	Ceiling(key K) (K, V, bool)
+	Higher(key K) (K, V, bool)
+	PopMin() (K, V, bool)
+	PopMax() (K, V, bool)
+
+	Rank(key K) int
+	Select(i int) (K, V, bool)
+	CountRange(lo, hi K) int
+	Validate() error
+}
+
+var (
+	_ SortedMap[int, int] = (*OrderedMap[int, int])(nil)
+	_ SortedMap[int, int] = (*BTreeMap[int, int])(nil)
+)
+
+type bTreeNode[K, V any] struct {
+	keys     []K
+	values   []V
+	children []*bTreeNode[K, V]
+
+	// size is the number of keys in the subtree, used by order statistics
+	size int
+}
+
+func (n *bTreeNode[K, V]) leaf() bool {
+	return len(n.children) == 0
+}
+
+// recount sets size from the keys and the sizes of the children
+func (n *bTreeNode[K, V]) recount() {
+	n.size = len(n.keys)
+	for _, child := range n.children {
+		n.size += child.size
+	}
+}
+
+const defaultBTreeDegree = 32
+
+// BTreeMap stores up to 2*degree-1 keys per node in contiguous slices, so
+// lookups touch O(log n / log degree) nodes instead of O(log n) pointers.
+// Every node except the root holds at least degree-1 keys. The zero value
+// uses defaultBTreeDegree and, for ordered keys, the natural order
+type BTreeMap[K, V any] struct {
+	root *bTreeNode[K, V]
+
+	degree  int
+	size    int
+	version int
+	compare func(K, K) int
+}
+
+func NewBTreeMap[K cmp.Ordered, V any](degree int) BTreeMap[K, V] {
+	return NewBTreeMapFunc[K, V](degree, cmp.Compare[K])
+}
+
+// NewBTreeMapFunc uses a degree of at least 2
+func NewBTreeMapFunc[K, V any](degree int, compare func(K, K) int) BTreeMap[K, V] {
+	return BTreeMap[K, V]{
+		degree:  max(degree, 2),
+		compare: compare,
+	}
+}
+
+// setDefaults prepares a zero value map before the first key is added, see
+// OrderedMap.setDefaultCompare
+func (m *BTreeMap[K, V]) setDefaults() {
+	if m.degree == 0 {
+		m.degree = defaultBTreeDegree
+	}
+
+	if m.compare != nil {
+		return
+	}
+
+	if m.compare = defaultCompare[K](); m.compare == nil {
+		var zero K
+		panic(fmt.Sprintf("BTreeMap: key type %T is not ordered, create the map with NewBTreeMapFunc", zero))
+	}
+}
+
+func (m *BTreeMap[K, V]) search(node *bTreeNode[K, V], key K) (int, bool) {
+	return slices.BinarySearchFunc(node.keys, key, m.compare)
+}
+
+func (m *BTreeMap[K, V]) full(node *bTreeNode[K, V]) bool {
+	return len(node.keys) == 2*m.degree-1
+}
+
+func (m *BTreeMap[K, V]) Insert(key K, value V) {
+	m.Upsert(key, value)
+}
+
+// Upsert sets the value for key and returns the previous one, if any
+func (m *BTreeMap[K, V]) Upsert(key K, value V) (V, bool) {
+	m.setDefaults()
+
+	if m.root == nil {
+		m.root = &bTreeNode[K, V]{}
+	}
+
+	if m.full(m.root) {
+		root := &bTreeNode[K, V]{children: []*bTreeNode[K, V]{m.root}}
+		m.splitChild(root, 0)
+		root.recount()
+		m.root = root
+	}
+
+	previous, found := m.insertNonFull(m.root, key, value)
+	if !found {
+		m.size += 1
+		m.version += 1
+	}
+
+	return previous, found
+}
+
+// insertNonFull splits full nodes on the way down, so there is always room
+// for a key moved up from a child. The sizes on the path only grow once the
+// key turns out to be new
+func (m *BTreeMap[K, V]) insertNonFull(node *bTreeNode[K, V], key K, value V) (V, bool) {
+	var buffer [16]*bTreeNode[K, V]
+	path := buffer[:0]
+
+	for {
+		i, found := m.search(node, key)
+		if found {
+			previous := node.values[i]
+			node.values[i] = value
+			return previous, true
+		}
+
+		if node.leaf() {
+			node.keys = slices.Insert(node.keys, i, key)
+			node.values = slices.Insert(node.values, i, value)
+			for _, ancestor := range append(path, node) {
+				ancestor.size += 1
+			}
+
+			var zero V
+			return zero, false
+		}
+
+		if m.full(node.children[i]) {
+			m.splitChild(node, i)
+			continue
+		}
+
+		path = append(path, node)
+		node = node.children[i]
+	}
+}
+
+func (m *BTreeMap[K, V]) splitChild(parent *bTreeNode[K, V], i int) {
+	child := parent.children[i]
+	middle := m.degree - 1
+
+	right := &bTreeNode[K, V]{
+		keys:   slices.Clone(child.keys[middle+1:]),
+		values: slices.Clone(child.values[middle+1:]),
+	}
+	if !child.leaf() {
+		right.children = slices.Clone(child.children[middle+1:])
+	}
+
+	parent.keys = slices.Insert(parent.keys, i, child.keys[middle])
+	parent.values = slices.Insert(parent.values, i, child.values[middle])
+	parent.children = slices.Insert(parent.children, i+1, right)
+
+	child.keys = slices.Delete(child.keys, middle, len(child.keys))
+	child.values = slices.Delete(child.values, middle, len(child.values))
+	if !child.leaf() {
+		child.children = slices.Delete(child.children, middle+1, len(child.children))
+	}
+
+	child.recount()
+	right.recount()
+}
+
+func (m *BTreeMap[K, V]) Erase(key K) {
+	if !m.Contains(key) {
+		return
+	}
+
+	m.erase(m.root, key)
+	m.size -= 1
+	m.version += 1
+
+	if len(m.root.keys) == 0 {
+		if m.root.leaf() {
+			m.root = nil
+		} else {
+			m.root = m.root.children[0]
+		}
+	}
+}
+
+// erase removes a key that is in the subtree of node. It makes sure every
+// child it descends into has at least degree keys, so removing a key never
+// leaves a node under the minimum
+func (m *BTreeMap[K, V]) erase(node *bTreeNode[K, V], key K) {
+	for {
+		// every node on the way loses the key, the children keep their
+		// sizes until the loop reaches them
+		node.size -= 1
+
+		i, found := m.search(node, key)
+		if node.leaf() {
+			node.keys = slices.Delete(node.keys, i, i+1)
+			node.values = slices.Delete(node.values, i, i+1)
+			return
+		}
+
+		if found {
+			left, right := node.children[i], node.children[i+1]
+			switch {
+			case len(left.keys) >= m.degree:
+				predecessor := left
+				for !predecessor.leaf() {
+					predecessor = predecessor.children[len(predecessor.children)-1]
+				}
+				last := len(predecessor.keys) - 1
+				node.keys[i], node.values[i] = predecessor.keys[last], predecessor.values[last]
+				node, key = left, predecessor.keys[last]
+			case len(right.keys) >= m.degree:
+				successor := right
+				for !successor.leaf() {
+					successor = successor.children[0]
+				}
+				node.keys[i], node.values[i] = successor.keys[0], successor.values[0]
+				node, key = right, successor.keys[0]
+			default:
+				m.mergeChildren(node, i)
+				node = left
+			}
+			continue
+		}
+
+		if len(node.children[i].keys) < m.degree {
+			i = m.fillChild(node, i)
+		}
+		node = node.children[i]
+	}
+}
+
+// fillChild gives the child at i at least degree keys by borrowing from a
+// sibling or merging with it, and returns the new index of the child
+func (m *BTreeMap[K, V]) fillChild(node *bTreeNode[K, V], i int) int {
+	child := node.children[i]
+
+	if i > 0 && len(node.children[i-1].keys) >= m.degree {
+		left := node.children[i-1]
+		last := len(left.keys) - 1
+
+		child.keys = slices.Insert(child.keys, 0, node.keys[i-1])
+		child.values = slices.Insert(child.values, 0, node.values[i-1])
+		node.keys[i-1], node.values[i-1] = left.keys[last], left.values[last]
+		left.keys, left.values = left.keys[:last], left.values[:last]
+
+		if !left.leaf() {
+			child.children = slices.Insert(child.children, 0, left.children[last+1])
+			left.children = left.children[:last+1]
+		}
+		left.recount()
+		child.recount()
+		return i
+	}
+
+	if i < len(node.keys) && len(node.children[i+1].keys) >= m.degree {
+		right := node.children[i+1]
+
+		child.keys = append(child.keys, node.keys[i])
+		child.values = append(child.values, node.values[i])
+		node.keys[i], node.values[i] = right.keys[0], right.values[0]
+		right.keys = slices.Delete(right.keys, 0, 1)
+		right.values = slices.Delete(right.values, 0, 1)
+
+		if !right.leaf() {
+			child.children = append(child.children, right.children[0])
+			right.children = slices.Delete(right.children, 0, 1)
+		}
+		right.recount()
+		child.recount()
+		return i
+	}
+
+	if i == len(node.keys) {
+		i--
+	}
+	m.mergeChildren(node, i)
+
+	return i
+}
+
+// mergeChildren moves the key at i and the child at i+1 into the child at i
+func (m *BTreeMap[K, V]) mergeChildren(node *bTreeNode[K, V], i int) {
+	left, right := node.children[i], node.children[i+1]
+
+	left.keys = append(append(left.keys, node.keys[i]), right.keys...)
+	left.values = append(append(left.values, node.values[i]), right.values...)
+	left.children = append(left.children, right.children...)
+	left.size += 1 + right.size
+
+	node.keys = slices.Delete(node.keys, i, i+1)
+	node.values = slices.Delete(node.values, i, i+1)
+	node.children = slices.Delete(node.children, i+1, i+2)
+}
+
+// find returns the node holding key and its index there, or nil
+func (m *BTreeMap[K, V]) find(key K) (*bTreeNode[K, V], int) {
+	for node := m.root; node != nil; {
+		i, found := m.search(node, key)
+		if found {
+			return node, i
+		}
+		if node.leaf() {
+			break
+		}
+		node = node.children[i]
+	}
+
+	return nil, 0
+}
+
+func (m *BTreeMap[K, V]) Get(key K) (V, bool) {
+	if node, i := m.find(key); node != nil {
+		return node.values[i], true
+	}
+
+	var zero V
+	return zero, false
+}
+
+func (m *BTreeMap[K, V]) GetOrDefault(key K, defaultValue V) V {
+	if value, found := m.Get(key); found {
+		return value
+	}
+
+	return defaultValue
+}
+
+func (m *BTreeMap[K, V]) Contains(key K) bool {
+	_, found := m.Get(key)
+	return found
+}
+
+func (m *BTreeMap[K, V]) Size() int {
+	return m.size
+}
+
+func (m *BTreeMap[K, V]) ForEach(action func(K, V)) {
+	if m.root != nil {
+		m.ascend(m.root, nil, nil, func(key K, value V) bool {
+			action(key, value)
+			return true
+		})
+	}
+}
+
+func (m *BTreeMap[K, V]) ForEachDescending(action func(K, V)) {
+	if m.root != nil {
+		m.descend(m.root, nil, nil, func(key K, value V) bool {
+			action(key, value)
+			return true
+		})
+	}
+}
+
+// Range visits the keys in [lo, hi) in ascending order until action
+// returns false
+func (m *BTreeMap[K, V]) Range(lo, hi K, action func(K, V) bool) {
+	if m.root != nil {
+		m.ascend(m.root, &lo, &hi, action)
+	}
+}
+
+// RangeDescending visits the keys in [lo, hi) in descending order until
+// action returns false
+func (m *BTreeMap[K, V]) RangeDescending(lo, hi K, action func(K, V) bool) {
+	if m.root != nil {
+		m.descend(m.root, &lo, &hi, action)
+	}
+}
+
+// descend mirrors ascend, a nil bound means the range is unbounded on
+// that side
+func (m *BTreeMap[K, V]) descend(node *bTreeNode[K, V], lo, hi *K, action func(K, V) bool) bool {
+	end := len(node.keys)
+	if hi != nil {
+		end, _ = m.search(node, *hi)
+	}
+
+	for i := end; i >= 0; i-- {
+		if !node.leaf() && !m.descend(node.children[i], lo, hi, action) {
+			return false
+		}
+		if i == 0 {
+			break
+		}
+		if lo != nil && m.compare(node.keys[i-1], *lo) < 0 {
+			return false
+		}
+		if !action(node.keys[i-1], node.values[i-1]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (m *BTreeMap[K, V]) Min() (K, V, bool) {
+	node := m.root
+	for node != nil && !node.leaf() {
+		node = node.children[0]
+	}
+
+	return bTreeEntry(node, 0)
+}
+
+func (m *BTreeMap[K, V]) Max() (K, V, bool) {
+	node := m.root
+	for node != nil && !node.leaf() {
+		node = node.children[len(node.children)-1]
+	}
+
+	if node == nil {
+		return bTreeEntry(node, 0)
+	}
+	return bTreeEntry(node, len(node.keys)-1)
+}
+
+// Floor returns the entry with the greatest key less than or equal to key
+func (m *BTreeMap[K, V]) Floor(key K) (K, V, bool) {
+	return m.floor(key, true)
+}
+
+// Lower returns the entry with the greatest key strictly less than key
+func (m *BTreeMap[K, V]) Lower(key K) (K, V, bool) {
+	return m.floor(key, false)
+}
+
+// Ceiling returns the entry with the least key greater than or equal to key
+func (m *BTreeMap[K, V]) Ceiling(key K) (K, V, bool) {
+	return m.ceiling(key, true)
+}
+
+// Higher returns the entry with the least key strictly greater than key
+func (m *BTreeMap[K, V]) Higher(key K) (K, V, bool) {
+	return m.ceiling(key, false)
+}
+
+func (m *BTreeMap[K, V]) PopMin() (K, V, bool) {
+	key, value, found := m.Min()
+	if found {
+		m.Erase(key)
+	}
+
+	return key, value, found
+}
+
+func (m *BTreeMap[K, V]) PopMax() (K, V, bool) {
+	key, value, found := m.Max()
+	if found {
+		m.Erase(key)
+	}
+
+	return key, value, found
+}
+
+// Rank returns the number of keys less than key
+func (m *BTreeMap[K, V]) Rank(key K) int {
+	rank := 0
+	for node := m.root; node != nil; {
+		i, found := m.search(node, key)
+		rank += i
+		if node.leaf() {
+			break
+		}
+		for _, child := range node.children[:i] {
+			rank += child.size
+		}
+		if found {
+			return rank + node.children[i].size
+		}
+		node = node.children[i]
+	}
+
+	return rank
+}
+
+// Select returns the entry with the i-th smallest key, counting from zero
+func (m *BTreeMap[K, V]) Select(i int) (K, V, bool) {
+	if i < 0 || i >= m.size {
+		return bTreeEntry[K, V](nil, 0)
+	}
+
+	node := m.root
+	for !node.leaf() {
+		j := 0
+		for ; i >= node.children[j].size; j++ {
+			i -= node.children[j].size
+			if i == 0 {
+				return bTreeEntry(node, j)
+			}
+			i--
+		}
+		node = node.children[j]
+	}
+
+	return bTreeEntry(node, i)
+}
+
+// CountRange returns the number of keys in [lo, hi)
+func (m *BTreeMap[K, V]) CountRange(lo, hi K) int {
+	return max(m.Rank(hi)-m.Rank(lo), 0)
+}
+
+// floor remembers the closest smaller separator key on the way down, the
+// child at i holds the keys between keys[i-1] and keys[i]
+func (m *BTreeMap[K, V]) floor(key K, inclusive bool) (K, V, bool) {
+	var (
+		result *bTreeNode[K, V]
+		index  int
+	)
+	for node := m.root; node != nil; {
+		i, found := m.search(node, key)
+		if found && inclusive {
+			return bTreeEntry(node, i)
+		}
+		if i > 0 {
+			result, index = node, i-1
+		}
+		if node.leaf() {
+			break
+		}
+		node = node.children[i]
+	}
+
+	return bTreeEntry(result, index)
+}
+
+func (m *BTreeMap[K, V]) ceiling(key K, inclusive bool) (K, V, bool) {
+	var (
+		result *bTreeNode[K, V]
+		index  int
+	)
+	for node := m.root; node != nil; {
+		i, found := m.search(node, key)
+		if found && inclusive {
+			return bTreeEntry(node, i)
+		}
+		if found {
+			i++
+		}
+		if i < len(node.keys) {
+			result, index = node, i
+		}
+		if node.leaf() {
+			break
+		}
+		node = node.children[i]
+	}
+
+	return bTreeEntry(result, index)
+}
+
+func bTreeEntry[K, V any](node *bTreeNode[K, V], i int) (K, V, bool) {
+	if node == nil || i >= len(node.keys) {
+		var (
+			key   K
+			value V
+		)
+		return key, value, false
+	}
+
+	return node.keys[i], node.values[i], true
+}
+
+func (m *BTreeMap[K, V]) ascend(node *bTreeNode[K, V], lo, hi *K, action func(K, V) bool) bool {
+	start := 0
+	if lo != nil {
+		start, _ = m.search(node, *lo)
+	}
+
+	for i := start; i <= len(node.keys); i++ {
+		if !node.leaf() && !m.ascend(node.children[i], lo, hi, action) {
+			return false
+		}
+		if i == len(node.keys) {
+			break
+		}
+		if hi != nil && m.compare(node.keys[i], *hi) >= 0 {
+			return false
+		}
+		if !action(node.keys[i], node.values[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (m *BTreeMap[K, V]) entries() ([]K, []V) {
+	keys := make([]K, 0, m.size)
+	values := make([]V, 0, m.size)
+	m.ForEach(func(key K, value V) {
+		keys = append(keys, key)
+		values = append(values, value)
+	})
+
+	return keys, values
+}
+
+// fromSortedEntries builds a map with the degree and comparator of m. The
+// keys are appended to the rightmost leaf, so this takes O(n log n)
+func (m *BTreeMap[K, V]) fromSortedEntries(compare func(K, K) int, keys []K, values []V) BTreeMap[K, V] {
+	result := BTreeMap[K, V]{degree: m.degree, compare: compare}
+	for i := range keys {
+		result.Insert(keys[i], values[i])
+	}
+
+	return result
+}
+
+func (m *BTreeMap[K, V]) collect(other *BTreeMap[K, V], pick func(key K, value, otherValue *V) *V) BTreeMap[K, V] {
+	var (
+		keys   []K
+		values []V
+	)
+	mine, mineValues := m.entries()
+	theirs, theirValues := other.entries()
+	mergeSorted(m.compare, mine, mineValues, theirs, theirValues, func(key K, value, otherValue *V) {
+		if picked := pick(key, value, otherValue); picked != nil {
+			keys = append(keys, key)
+			values = append(values, *picked)
+		}
+	})
+
+	// see OrderedMap.collect
+	compare := m.compare
+	if compare == nil {
+		compare = other.compare
+	}
+
+	return m.fromSortedEntries(compare, keys, values)
+}
+
+// Merge adds all entries of other to m, resolving conflicts like
+// OrderedMap.Merge
+func (m *BTreeMap[K, V]) Merge(other *BTreeMap[K, V], conflict func(key K, value, otherValue V) V) {
+	merged := m.collect(other, func(key K, value, otherValue *V) *V {
+		if value != nil && otherValue != nil && conflict != nil {
+			resolved := conflict(key, *value, *otherValue)
+			return &resolved
+		}
+		if otherValue != nil {
+			return otherValue
+		}
+		return value
+	})
+
+	m.root, m.size, m.compare = merged.root, merged.size, merged.compare
+	m.degree = merged.degree
+	m.version += 1
+}
+
+// Split returns the entries with keys less than key and the rest as two
+// new maps, leaving m unchanged
+func (m *BTreeMap[K, V]) Split(key K) (BTreeMap[K, V], BTreeMap[K, V]) {
+	keys, values := m.entries()
+	index := m.Rank(key)
+
+	return m.fromSortedEntries(m.compare, keys[:index], values[:index]), m.fromSortedEntries(m.compare, keys[index:], values[index:])
+}
+
+// Union returns the keys of both maps, preferring values from m
+func (m *BTreeMap[K, V]) Union(other *BTreeMap[K, V]) BTreeMap[K, V] {
+	return m.collect(other, func(_ K, value, otherValue *V) *V {
+		if value != nil {
+			return value
+		}
+		return otherValue
+	})
+}
+
+// Intersection returns the keys present in both maps with values from m
+func (m *BTreeMap[K, V]) Intersection(other *BTreeMap[K, V]) BTreeMap[K, V] {
+	return m.collect(other, func(_ K, value, otherValue *V) *V {
+		if otherValue == nil {
+			return nil
+		}
+		return value
+	})
+}
+
+// Difference returns the keys of m that are not present in other
+func (m *BTreeMap[K, V]) Difference(other *BTreeMap[K, V]) BTreeMap[K, V] {
+	return m.collect(other, func(_ K, value, otherValue *V) *V {
+		if otherValue != nil {
+			return nil
+		}
+		return value
+	})
+}
+
+// bTreeFrame is a position on the iterator path. The last frame points at
+// the current key, the others at the child the path goes through
+type bTreeFrame[K, V any] struct {
+	node  *bTreeNode[K, V]
+	index int
+}
+
+// BTreeIterator works like Iterator: it keeps the path from the root and
+// seeks to the neighbour of the current key if the map has been modified.
+// Keys move between nodes on modifications, so the current entry is copied
+type BTreeIterator[K, V any] struct {
+	m       *BTreeMap[K, V]
+	path    []bTreeFrame[K, V]
+	version int
+
+	key   K
+	value V
+}
+
+func (m *BTreeMap[K, V]) Iterator() *BTreeIterator[K, V] {
+	return &BTreeIterator[K, V]{m: m}
+}
+
+func (it *BTreeIterator[K, V]) Valid() bool {
+	return len(it.path) > 0
+}
+
+// Key and Value must only be called on a valid iterator
+func (it *BTreeIterator[K, V]) Key() K {
+	return it.key
+}
+
+// Value reflects updates of the current key unless the map has been
+// modified, then it returns the value the iterator was positioned at
+func (it *BTreeIterator[K, V]) Value() V {
+	if it.version == it.m.version {
+		top := it.top()
+		return top.node.values[top.index]
+	}
+
+	return it.value
+}
+
+func (it *BTreeIterator[K, V]) First() bool {
+	it.reset()
+	if it.m.root != nil {
+		it.descendFirst(it.m.root)
+	}
+
+	return it.current()
+}
+
+func (it *BTreeIterator[K, V]) Last() bool {
+	it.reset()
+	if it.m.root != nil {
+		it.descendLast(it.m.root)
+	}
+
+	return it.current()
+}
+
+// Seek positions the iterator at the least key greater than or equal to key
+func (it *BTreeIterator[K, V]) Seek(key K) bool {
+	return it.seek(key, true, true)
+}
+
+func (it *BTreeIterator[K, V]) Next() bool {
+	if !it.Valid() {
+		return false
+	}
+	if it.version != it.m.version {
+		return it.seek(it.Key(), false, true)
+	}
+
+	top := it.top()
+	top.index += 1
+	if !top.node.leaf() {
+		it.descendFirst(top.node.children[top.index])
+		return it.current()
+	}
+
+	return it.climbAscending()
+}
+
+func (it *BTreeIterator[K, V]) Prev() bool {
+	if !it.Valid() {
+		return false
+	}
+	if it.version != it.m.version {
+		return it.seek(it.Key(), false, false)
+	}
+
+	top := it.top()
+	if !top.node.leaf() {
+		it.descendLast(top.node.children[top.index])
+		return it.current()
+	}
+
+	top.index -= 1
+	return it.climbDescending()
+}
+
+// seek finds the closest key after (ascending) or before the given one, the
+// leaf frame ends up right after or at the last key before it
+func (it *BTreeIterator[K, V]) seek(key K, inclusive, ascending bool) bool {
+	it.reset()
+
+	for node := it.m.root; node != nil; {
+		i, found := it.m.search(node, key)
+		if found && inclusive {
+			it.path = append(it.path, bTreeFrame[K, V]{node: node, index: i})
+			return it.current()
+		}
+		if found && ascending {
+			i++
+		}
+
+		it.path = append(it.path, bTreeFrame[K, V]{node: node, index: i})
+		if node.leaf() {
+			break
+		}
+		node = node.children[i]
+	}
+
+	if !it.Valid() {
+		return false
+	}
+	if ascending {
+		return it.climbAscending()
+	}
+
+	it.top().index -= 1
+	return it.climbDescending()
+}
+
+func (it *BTreeIterator[K, V]) descendFirst(node *bTreeNode[K, V]) {
+	for {
+		it.path = append(it.path, bTreeFrame[K, V]{node: node})
+		if node.leaf() {
+			return
+		}
+		node = node.children[0]
+	}
+}
+
+func (it *BTreeIterator[K, V]) descendLast(node *bTreeNode[K, V]) {
+	for !node.leaf() {
+		it.path = append(it.path, bTreeFrame[K, V]{node: node, index: len(node.children) - 1})
+		node = node.children[len(node.children)-1]
+	}
+	it.path = append(it.path, bTreeFrame[K, V]{node: node, index: len(node.keys) - 1})
+}
+
+// climbAscending leaves the frames past their last key, a parent frame
+// that went through child i continues at key i
+func (it *BTreeIterator[K, V]) climbAscending() bool {
+	for it.Valid() && it.top().index == len(it.top().node.keys) {
+		it.path = it.path[:len(it.path)-1]
+	}
+
+	return it.current()
+}
+
+// climbDescending leaves the frames before their first key, a parent frame
+// that went through child i continues at key i-1
+func (it *BTreeIterator[K, V]) climbDescending() bool {
+	for it.Valid() && it.top().index < 0 {
+		it.path = it.path[:len(it.path)-1]
+		if it.Valid() {
+			it.top().index -= 1
+		}
+	}
+
+	return it.current()
+}
+
+// current copies the entry the iterator stopped at
+func (it *BTreeIterator[K, V]) current() bool {
+	if !it.Valid() {
+		return false
+	}
+
+	top := it.top()
+	it.key, it.value = top.node.keys[top.index], top.node.values[top.index]
+
+	return true
+}
+
+func (it *BTreeIterator[K, V]) top() *bTreeFrame[K, V] {
+	return &it.path[len(it.path)-1]
+}
+
+func (it *BTreeIterator[K, V]) reset() {
+	it.path = it.path[:0]
+	it.version = it.m.version
+}
+
+// Validate checks key ordering, node fill factors, that all leaves are at
+// the same depth, the subtree sizes and the map size
+func (m *BTreeMap[K, V]) Validate() error {
+	if m.root == nil {
+		if m.size != 0 {
+			return fmt.Errorf("map size is %d, but the tree is empty", m.size)
+		}
+		return nil
+	}
+
+	leafDepth := -1
+	size, err := m.validate(m.root, nil, nil, 0, &leafDepth)
+	if err != nil {
+		return err
+	}
+
+	if size != m.size {
+		return fmt.Errorf("map size is %d, but the tree has %d keys", m.size, size)
+	}
+
+	return nil
+}
+
+func (m *BTreeMap[K, V]) validate(node *bTreeNode[K, V], lo, hi *K, depth int, leafDepth *int) (int, error) {
+	if node != m.root && len(node.keys) < m.degree-1 || len(node.keys) > 2*m.degree-1 {
+		return 0, fmt.Errorf("node at depth %d has %d keys", depth, len(node.keys))
+	}
+	if len(node.values) != len(node.keys) {
+		return 0, fmt.Errorf("node at depth %d has %d keys and %d values", depth, len(node.keys), len(node.values))
+	}
+
+	for i, key := range node.keys {
+		if i > 0 && m.compare(node.keys[i-1], key) >= 0 ||
+			lo != nil && m.compare(key, *lo) <= 0 || hi != nil && m.compare(key, *hi) >= 0 {
+			return 0, fmt.Errorf("key %v is out of order", key)
+		}
+	}
+
+	if node.leaf() {
+		if *leafDepth == -1 {
+			*leafDepth = depth
+		}
+		if *leafDepth != depth {
+			return 0, fmt.Errorf("leaves at depths %d and %d", *leafDepth, depth)
+		}
+		if node.size != len(node.keys) {
+			return 0, fmt.Errorf("leaf at depth %d has size %d, but %d keys", depth, node.size, len(node.keys))
+		}
+		return len(node.keys), nil
+	}
+
+	if len(node.children) != len(node.keys)+1 {
+		return 0, fmt.Errorf("node at depth %d has %d keys and %d children", depth, len(node.keys), len(node.children))
+	}
+
+	size := len(node.keys)
+	for i, child := range node.children {
+		childLo, childHi := lo, hi
+		if i > 0 {
+			childLo = &node.keys[i-1]
+		}
+		if i < len(node.keys) {
+			childHi = &node.keys[i]
+		}
+
+		childSize, err := m.validate(child, childLo, childHi, depth+1, leafDepth)
+		if err != nil {
+			return 0, err
+		}
+		size += childSize
+	}
+
+	if node.size != size {
+		return 0, fmt.Errorf("node at depth %d has size %d, but %d keys in the subtree", depth, node.size, size)
+	}
+
+	return size, nil
+}
+
+func TestBTreeMap(t *testing.T) {
+	data := NewBTreeMap[int, int](2)
+	assert.Zero(t, data.Size())
+	assert.False(t, data.Contains(1))
+	data.Erase(1)
+
+	for _, key := range []int{10, 5, 15, 2, 4, 12, 14, 1, 3, 20, 25, 30} {
+		data.Insert(key, key*10)
+	}
+	data.Insert(4, 44)
+
+	assert.NoError(t, data.Validate())
+	assert.Equal(t, 12, data.Size())
+	assert.Equal(t, 44, data.GetOrDefault(4, 0))
+	assert.Equal(t, 150, data.GetOrDefault(15, 0))
+	assert.Equal(t, -1, data.GetOrDefault(13, -1))
+
+	var keys []int
+	data.ForEach(func(key, _ int) {
+		keys = append(keys, key)
+	})
+	assert.Equal(t, []int{1, 2, 3, 4, 5, 10, 12, 14, 15, 20, 25, 30}, keys)
+
+	keys = nil
+	data.Range(4, 15, func(key, _ int) bool {
+		keys = append(keys, key)
+		return true
+	})
+	assert.Equal(t, []int{4, 5, 10, 12, 14}, keys)
+
+	keys = nil
+	data.Range(4, 100, func(key, _ int) bool {
+		keys = append(keys, key)
+		return len(keys) < 3
+	})
+	assert.Equal(t, []int{4, 5, 10}, keys)
+
+	for _, key := range []int{10, 5, 4, 100, 1, 30, 12} {
+		data.Erase(key)
+		assert.NoError(t, data.Validate())
+		assert.False(t, data.Contains(key))
+	}
+	assert.Equal(t, 6, data.Size())
+
+	for _, key := range []int{2, 3, 14, 15, 20, 25} {
+		data.Erase(key)
+	}
+	assert.NoError(t, data.Validate())
+	assert.Zero(t, data.Size())
+	assert.Nil(t, data.root)
+}
+
+func TestBTreeMapDifferential(t *testing.T) {
+	for _, degree := range []int{2, 3, 8, 32} {
+		t.Run(fmt.Sprintf("degree %d", degree), func(t *testing.T) {
+			random := rand.New(rand.NewSource(int64(degree)))
+			data := NewBTreeMap[int, int](degree)
+			reference := make(map[int]int)
+
+			for i := 0; i < 20_000; i++ {
+				key := random.Intn(1000)
+				if random.Intn(3) == 0 {
+					data.Erase(key)
+					delete(reference, key)
+				} else {
+					data.Insert(key, i)
+					reference[key] = i
+				}
+
+				if i%500 == 0 {
+					if err := data.Validate(); err != nil {
+						t.Fatalf("operation %d: %v", i, err)
+					}
+				}
+			}
+
+			assert.NoError(t, data.Validate())
+			assert.Equal(t, len(reference), data.Size())
+
+			keys := sortedReferenceKeys(reference)
+			actual := make([]int, 0, len(keys))
+			data.ForEach(func(key, value int) {
+				assert.Equal(t, reference[key], value)
+				actual = append(actual, key)
+			})
+			assert.Equal(t, keys, actual)
+		})
+	}
+}
+
+func TestSortedMapImplementations(t *testing.T) {
+	implementations := map[string]func() SortedMap[int, int]{
+		"ordered map": func() SortedMap[int, int] {
+			data := NewOrderedMap[int, int]()
+			return &data
+		},
+		"b-tree degree 2": func() SortedMap[int, int] {
+			data := NewBTreeMap[int, int](2)
+			return &data
+		},
+		"b-tree degree 5": func() SortedMap[int, int] {
+			data := NewBTreeMap[int, int](5)
+			return &data
+		},
+	}
+
+	for name, create := range implementations {
+		t.Run(name, func(t *testing.T) {
+			testSortedMap(t, create())
+		})
+	}
+}
+
+func testSortedMap(t *testing.T, data SortedMap[int, int]) {
+	_, _, found := data.Min()
+	assert.False(t, found)
+	_, _, found = data.Max()
+	assert.False(t, found)
+	_, _, found = data.Floor(1)
+	assert.False(t, found)
+	_, _, found = data.Ceiling(1)
+	assert.False(t, found)
+	_, _, found = data.PopMin()
+	assert.False(t, found)
+	data.Range(0, 100, func(int, int) bool {
+		assert.Fail(t, "unexpected key in empty map")
+		return true
+	})
+
+	// keys 10, 20, ..., 500 inserted in a scrambled order
+	random := rand.New(rand.NewSource(3))
+	for _, i := range random.Perm(50) {
+		data.Insert((i+1)*10, i+1)
+	}
+
+	previous, found := data.Upsert(250, -25)
+	assert.True(t, found)
+	assert.Equal(t, 25, previous)
+	_, found = data.Upsert(255, 0)
+	assert.False(t, found)
+	data.Erase(255)
+	assert.Equal(t, 50, data.Size())
+
+	key, _, _ := data.Min()
+	assert.Equal(t, 10, key)
+	key, _, _ = data.Max()
+	assert.Equal(t, 500, key)
+
+	queries := []struct {
+		name     string
+		query    func(int) (int, int, bool)
+		key      int
+		expected int
+		found    bool
+	}{
+		{"floor exact", data.Floor, 250, 250, true},
+		{"floor between", data.Floor, 255, 250, true},
+		{"floor below", data.Floor, 5, 0, false},
+		{"lower exact", data.Lower, 250, 240, true},
+		{"lower min", data.Lower, 10, 0, false},
+		{"ceiling exact", data.Ceiling, 250, 250, true},
+		{"ceiling between", data.Ceiling, 255, 260, true},
+		{"ceiling above", data.Ceiling, 505, 0, false},
+		{"higher exact", data.Higher, 250, 260, true},
+		{"higher max", data.Higher, 500, 0, false},
+	}
+	for _, test := range queries {
+		key, _, found := test.query(test.key)
+		assert.Equal(t, test.found, found, test.name)
+		assert.Equal(t, test.expected, key, test.name)
+	}
+
+	collect := func(iterate func(func(int, int) bool), limit int) []int {
+		var keys []int
+		iterate(func(key, _ int) bool {
+			keys = append(keys, key)
+			return len(keys) < limit
+		})
+		return keys
+	}
+
+	assert.Equal(t, []int{120, 130, 140}, collect(func(action func(int, int) bool) {
+		data.Range(115, 150, action)
+	}, 100))
+	assert.Equal(t, []int{140, 130, 120}, collect(func(action func(int, int) bool) {
+		data.RangeDescending(115, 150, action)
+	}, 100))
+	assert.Equal(t, []int{500, 490}, collect(func(action func(int, int) bool) {
+		data.RangeDescending(0, 1000, action)
+	}, 2))
+	assert.Empty(t, collect(func(action func(int, int) bool) {
+		data.RangeDescending(150, 150, action)
+	}, 100))
+
+	var keys []int
+	data.ForEachDescending(func(key, _ int) {
+		keys = append(keys, key)
+	})
+	assert.Len(t, keys, 50)
+	assert.True(t, slices.IsSortedFunc(keys, func(a, b int) int { return b - a }))
+
+	key, value, found := data.PopMin()
+	assert.True(t, found)
+	assert.Equal(t, 10, key)
+	assert.Equal(t, 1, value)
+	key, value, found = data.PopMax()
+	assert.True(t, found)
+	assert.Equal(t, 500, key)
+	assert.Equal(t, 50, value)
+	assert.Equal(t, 48, data.Size())
+	assert.False(t, data.Contains(10))
+	assert.Equal(t, -25, data.GetOrDefault(250, 0))
+	assert.NoError(t, data.Validate())
+
+	// keys 20, 30, ..., 490 remain
+	assert.Equal(t, 0, data.Rank(20))
+	assert.Equal(t, 4, data.Rank(55))
+	assert.Equal(t, 48, data.Rank(1000))
+	key, value, found = data.Select(3)
+	assert.True(t, found)
+	assert.Equal(t, 50, key)
+	assert.Equal(t, 5, value)
+	key, _, _ = data.Select(47)
+	assert.Equal(t, 490, key)
+	_, _, found = data.Select(48)
+	assert.False(t, found)
+	_, _, found = data.Select(-1)
+	assert.False(t, found)
+	assert.Equal(t, 4, data.CountRange(100, 140))
+	assert.Equal(t, 0, data.CountRange(140, 100))
+}
+
+func TestBTreeMapZeroValue(t *testing.T) {
+	var data BTreeMap[int, int]
+	for i := 0; i < 1000; i++ {
+		data.Insert(i%7*1000+i, i)
+	}
+	assert.NoError(t, data.Validate())
+	assert.Equal(t, 1000, data.Size())
+	assert.Equal(t, defaultBTreeDegree, data.degree)
+
+	type priority int8
+	var named BTreeMap[priority, string]
+	named.Insert(5, "high")
+	named.Insert(-1, "low")
+	key, _, _ := named.Min()
+	assert.Equal(t, priority(-1), key)
+
+	type point struct {
+		x, y int
+	}
+	var unordered BTreeMap[point, int]
+	assert.PanicsWithValue(t, "BTreeMap: key type main.point is not ordered, create the map with NewBTreeMapFunc", func() {
+		unordered.Insert(point{1, 2}, 1)
+	})
+	assert.False(t, unordered.Contains(point{1, 2}))
+}
+
+func TestBTreeMapOrderStatistics(t *testing.T) {
+	random := rand.New(rand.NewSource(11))
+	data := NewBTreeMap[int, int](3)
+	reference := make(map[int]int)
+
+	for i := 0; i < 5000; i++ {
+		key := random.Intn(500)
+		if random.Intn(3) == 0 {
+			data.Erase(key)
+			delete(reference, key)
+		} else {
+			data.Insert(key, i)
+			reference[key] = i
+		}
+	}
+	require.NoError(t, data.Validate())
+
+	keys := sortedReferenceKeys(reference)
+	for i, key := range keys {
+		assert.Equal(t, i, data.Rank(key))
+		selected, value, found := data.Select(i)
+		assert.True(t, found)
+		assert.Equal(t, key, selected)
+		assert.Equal(t, reference[key], value)
+	}
+	for q := 0; q < 100; q++ {
+		lo, hi := random.Intn(550)-25, random.Intn(550)-25
+		expected := 0
+		for _, key := range keys {
+			if key >= lo && key < hi {
+				expected++
+			}
+		}
+		assert.Equal(t, expected, data.CountRange(lo, hi))
+	}
+}
+
+func TestBTreeMapIterator(t *testing.T) {
+	data := NewBTreeMap[int, string](2)
+	it := data.Iterator()
+	assert.False(t, it.First())
+	assert.False(t, it.Last())
+	assert.False(t, it.Seek(1))
+	assert.False(t, it.Next())
+
+	for i := 1; i <= 50; i++ {
+		data.Insert(i*2, strconv.Itoa(i*2))
+	}
+
+	var keys []int
+	for ok := it.First(); ok; ok = it.Next() {
+		assert.Equal(t, strconv.Itoa(it.Key()), it.Value())
+		keys = append(keys, it.Key())
+	}
+	assert.Len(t, keys, 50)
+	assert.True(t, slices.IsSorted(keys))
+
+	keys = keys[:0]
+	for ok := it.Last(); ok; ok = it.Prev() {
+		keys = append(keys, it.Key())
+	}
+	assert.Len(t, keys, 50)
+	assert.Equal(t, 100, keys[0])
+	assert.Equal(t, 2, keys[49])
+
+	assert.True(t, it.Seek(31))
+	assert.Equal(t, 32, it.Key())
+	assert.True(t, it.Seek(32))
+	assert.Equal(t, 32, it.Key())
+	assert.True(t, it.Prev())
+	assert.Equal(t, 30, it.Key())
+	assert.False(t, it.Seek(101))
+
+	// modifications make the iterator seek to the neighbour of its key
+	assert.True(t, it.Seek(40))
+	data.Erase(40)
+	data.Erase(42)
+	data.Insert(41, "41")
+	assert.True(t, it.Next())
+	assert.Equal(t, 41, it.Key())
+	data.Erase(38)
+	assert.True(t, it.Prev())
+	assert.Equal(t, 36, it.Key())
+}
+
+func TestBTreeMapIteratorAgainstOrderedMap(t *testing.T) {
+	random := rand.New(rand.NewSource(5))
+	for _, degree := range []int{2, 4} {
+		data := NewBTreeMap[int, int](degree)
+		reference := NewOrderedMap[int, int]()
+		for i := 0; i < 2000; i++ {
+			key := random.Intn(300)
+			if random.Intn(3) == 0 {
+				data.Erase(key)
+				reference.Erase(key)
+			} else {
+				data.Insert(key, i)
+				reference.Insert(key, i)
+			}
+		}
+
+		it, expected := data.Iterator(), reference.Iterator()
+		for q := 0; q < 200; q++ {
+			key := random.Intn(320) - 10
+			assert.Equal(t, expected.Seek(key), it.Seek(key))
+
+			forward := random.Intn(2) == 0
+			for steps := 0; steps < 20 && expected.Valid(); steps++ {
+				require.True(t, it.Valid())
+				assert.Equal(t, expected.Key(), it.Key())
+				assert.Equal(t, expected.Value(), it.Value())
+				if forward {
+					assert.Equal(t, expected.Next(), it.Next())
+				} else {
+					assert.Equal(t, expected.Prev(), it.Prev())
+				}
+			}
+		}
+	}
+}
+
+func TestBTreeMapBulkOperations(t *testing.T) {
+	build := func(keys ...int) (BTreeMap[int, int], OrderedMap[int, int]) {
+		data := NewBTreeMap[int, int](2)
+		reference := NewOrderedMap[int, int]()
+		for _, key := range keys {
+			data.Insert(key, key*10)
+			reference.Insert(key, key*10)
+		}
+		return data, reference
+	}
+	entriesOf := func(data interface{ ForEach(func(int, int)) }) [][2]int {
+		var entries [][2]int
+		data.ForEach(func(key, value int) {
+			entries = append(entries, [2]int{key, value})
+		})
+		return entries
+	}
+
+	left, leftReference := build(1, 3, 5, 7, 9, 11)
+	right, rightReference := build(2, 3, 4, 9, 10)
+	for i := 0; i < 5; i++ {
+		right.Insert(3, -3)
+		rightReference.Insert(3, -3)
+	}
+
+	union, expected := left.Union(&right), leftReference.Union(&rightReference)
+	assert.Equal(t, entriesOf(&expected), entriesOf(&union))
+	assert.NoError(t, union.Validate())
+
+	intersection, expected := left.Intersection(&right), leftReference.Intersection(&rightReference)
+	assert.Equal(t, entriesOf(&expected), entriesOf(&intersection))
+
+	difference, expected := left.Difference(&right), leftReference.Difference(&rightReference)
+	assert.Equal(t, entriesOf(&expected), entriesOf(&difference))
+
+	lower, upper := left.Split(6)
+	assert.Equal(t, [][2]int{{1, 10}, {3, 30}, {5, 50}}, entriesOf(&lower))
+	assert.Equal(t, [][2]int{{7, 70}, {9, 90}, {11, 110}}, entriesOf(&upper))
+	assert.Equal(t, 6, left.Size())
+
+	sum := func(_ int, value, otherValue int) int {
+		return value + otherValue
+	}
+	left.Merge(&right, sum)
+	leftReference.Merge(&rightReference, sum)
+	assert.Equal(t, entriesOf(&leftReference), entriesOf(&left))
+	assert.NoError(t, left.Validate())
+
+	var empty BTreeMap[int, int]
+	empty.Merge(&right, nil)
+	empty.Insert(100, 1)
+	assert.NoError(t, empty.Validate())
+	assert.Equal(t, 6, empty.Size())
+}
+
+const benchmarkBTreeDegree = 32
+
+func BenchmarkBTreeMapInsertRandom(b *testing.B) {
+	keys := randomKeys()
+	for i := 0; i < b.N; i++ {
+		data := NewBTreeMap[int, int](benchmarkBTreeDegree)
+		for _, key := range keys {
+			data.Insert(key, key)
+		}
+	}
+}
+
+func BenchmarkBTreeMapContainsRandom(b *testing.B) {
+	keys := randomKeys()
+	data := NewBTreeMap[int, int](benchmarkBTreeDegree)
+	for _, key := range keys {
+		data.Insert(key, key)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		data.Contains(keys[i%len(keys)])
+	}
+}
+
+func BenchmarkBTreeMapForEach(b *testing.B) {
+	data := NewBTreeMap[int, int](benchmarkBTreeDegree)
+	for _, key := range randomKeys() {
+		data.Insert(key, key)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sum := 0
+		data.ForEach(func(key, _ int) {
+			sum += key
+		})
+	}
+}
+
+func BenchmarkOrderedMapForEach(b *testing.B) {
+	data := NewOrderedMap[int, int]()
+	for _, key := range randomKeys() {
+		data.Insert(key, key)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sum := 0
+		data.ForEach(func(key, _ int) {
+			sum += key
+		})
+	}
+}
@@ -1,166 +1,910 @@
 package main
 
 import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
 	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"unsafe"
 
 	"github.com/stretchr/testify/assert"
 )
 
 // go test -v homework_test.go
 
-type Node struct {
-	Key   int
-	Value int
+type Node[K, V any] struct {
+	Key   K
+	Value V
 
-	Left  *Node
-	Right *Node
+	Left  *Node[K, V]
+	Right *Node[K, V]
+
+	Height int
+	// Size is the number of nodes in the subtree, used by order statistics
+	Size int
+}
+
+func height[K, V any](node *Node[K, V]) int {
+	if node == nil {
+		return 0
+	}
+
+	return node.Height
+}
+
+func subtreeSize[K, V any](node *Node[K, V]) int {
+	if node == nil {
+		return 0
+	}
+
+	return node.Size
+}
+
+func (n *Node[K, V]) update() {
+	n.Height = 1 + max(height(n.Left), height(n.Right))
+	n.Size = 1 + subtreeSize(n.Left) + subtreeSize(n.Right)
+}
+
+func rotateLeft[K, V any](node *Node[K, V]) *Node[K, V] {
+	return rotateLeftFunc(node, (*Node[K, V]).update)
+}
+
+func rotateRight[K, V any](node *Node[K, V]) *Node[K, V] {
+	return rotateRightFunc(node, (*Node[K, V]).update)
+}
+
+// rebalance restores the AVL invariant |height(Left) - height(Right)| <= 1
+// after a single insertion or deletion below node
+func rebalance[K, V any](node *Node[K, V]) *Node[K, V] {
+	return rebalanceFunc(node, (*Node[K, V]).update)
+}
+
+func eraseMin[K, V any](node *Node[K, V]) (*Node[K, V], *Node[K, V]) {
+	return eraseMinFunc(node, (*Node[K, V]).update)
+}
+
+// The Func variants let augmented trees keep extra per-node data up to
+// date, update is called wherever Node.update would be and must call it
+
+func rotateLeftFunc[K, V any](node *Node[K, V], update func(*Node[K, V])) *Node[K, V] {
+	right := node.Right
+	node.Right = right.Left
+	right.Left = node
+
+	update(node)
+	update(right)
+
+	return right
+}
+
+func rotateRightFunc[K, V any](node *Node[K, V], update func(*Node[K, V])) *Node[K, V] {
+	left := node.Left
+	node.Left = left.Right
+	left.Right = node
+
+	update(node)
+	update(left)
+
+	return left
+}
+
+func rebalanceFunc[K, V any](node *Node[K, V], update func(*Node[K, V])) *Node[K, V] {
+	update(node)
+
+	switch balance := height(node.Left) - height(node.Right); {
+	case balance > 1:
+		if height(node.Left.Left) < height(node.Left.Right) {
+			node.Left = rotateLeftFunc(node.Left, update)
+		}
+		return rotateRightFunc(node, update)
+	case balance < -1:
+		if height(node.Right.Right) < height(node.Right.Left) {
+			node.Right = rotateRightFunc(node.Right, update)
+		}
+		return rotateLeftFunc(node, update)
+	}
+
+	return node
+}
+
+func eraseMinFunc[K, V any](node *Node[K, V], update func(*Node[K, V])) (*Node[K, V], *Node[K, V]) {
+	if node.Left == nil {
+		return node.Right, node
+	}
+
+	var min *Node[K, V]
+	node.Left, min = eraseMinFunc(node.Left, update)
+
+	return rebalanceFunc(node, update), min
+}
+
+func inorderTraverse[K, V any](node *Node[K, V], action func(K, V)) {
+	if node == nil {
+		return
+	}
+
+	inorderTraverse(node.Left, action)
+	action(node.Key, node.Value)
+	inorderTraverse(node.Right, action)
+}
+
+func reverseInorderTraverse[K, V any](node *Node[K, V], action func(K, V)) {
+	if node == nil {
+		return
+	}
+
+	reverseInorderTraverse(node.Right, action)
+	action(node.Key, node.Value)
+	reverseInorderTraverse(node.Left, action)
+}
+
+// OrderedMap is an AVL tree, so the height stays below 1.45*log2(n+2) and
+// every operation is O(log n) regardless of the insertion order
+type OrderedMap[K, V any] struct {
+	bst *Node[K, V]
+
+	size    int
+	version int
+	compare func(K, K) int
+}
+
+func NewOrderedMap[K cmp.Ordered, V any]() OrderedMap[K, V] {
+	return NewOrderedMapFunc[K, V](cmp.Compare[K])
+}
+
+// NewOrderedMapFunc orders keys with compare, which returns a negative
+// number, zero or a positive number like cmp.Compare. It is required for key
+// types that aren't ordered, for ordered ones the zero value is ready to use
+func NewOrderedMapFunc[K, V any](compare func(K, K) int) OrderedMap[K, V] {
+	return OrderedMap[K, V]{
+		size:    0,
+		compare: compare,
+	}
+}
+
+// setDefaultCompare picks the natural order for zero value maps before the
+// first key is added, so a map with entries always has a comparator and
+// read paths never write it. It panics if the key type isn't ordered, since
+// there is no way to guess the order
+func (m *OrderedMap[K, V]) setDefaultCompare() {
+	if m.compare != nil {
+		return
+	}
+
+	if m.compare = defaultCompare[K](); m.compare == nil {
+		var zero K
+		panic(fmt.Sprintf("OrderedMap: key type %T is not ordered, create the map with NewOrderedMapFunc", zero))
+	}
+}
+
+// defaultCompare returns cmp.Compare for ordered key types and nil for the
+// rest. Named types, like type ID int, are compared as their underlying
+// type, which is resolved once here rather than on every comparison
+func defaultCompare[K any]() func(K, K) int {
+	var zero K
+	switch reflect.TypeOf(&zero).Elem().Kind() {
+	case reflect.Int:
+		return underlyingCompare[K, int]
+	case reflect.Int8:
+		return underlyingCompare[K, int8]
+	case reflect.Int16:
+		return underlyingCompare[K, int16]
+	case reflect.Int32:
+		return underlyingCompare[K, int32]
+	case reflect.Int64:
+		return underlyingCompare[K, int64]
+	case reflect.Uint:
+		return underlyingCompare[K, uint]
+	case reflect.Uint8:
+		return underlyingCompare[K, uint8]
+	case reflect.Uint16:
+		return underlyingCompare[K, uint16]
+	case reflect.Uint32:
+		return underlyingCompare[K, uint32]
+	case reflect.Uint64:
+		return underlyingCompare[K, uint64]
+	case reflect.Uintptr:
+		return underlyingCompare[K, uintptr]
+	case reflect.Float32:
+		return underlyingCompare[K, float32]
+	case reflect.Float64:
+		return underlyingCompare[K, float64]
+	case reflect.String:
+		return underlyingCompare[K, string]
+	}
+
+	return nil
+}
+
+// underlyingCompare compares keys as U, K must have U as its underlying type
+func underlyingCompare[K any, U cmp.Ordered](a, b K) int {
+	return cmp.Compare(*(*U)(unsafe.Pointer(&a)), *(*U)(unsafe.Pointer(&b)))
+}
+
+func (m *OrderedMap[K, V]) Insert(key K, value V) {
+	m.Upsert(key, value)
+}
+
+// Upsert sets the value for key and returns the previous one, if any
+func (m *OrderedMap[K, V]) Upsert(key K, value V) (V, bool) {
+	m.setDefaultCompare()
+
+	var (
+		previous V
+		found    bool
+	)
+	m.bst = m.upsert(m.bst, key, value, &previous, &found)
+	if !found {
+		m.size += 1
+		m.version += 1
+	}
+
+	return previous, found
+}
+
+func (m *OrderedMap[K, V]) upsert(node *Node[K, V], key K, value V, previous *V, found *bool) *Node[K, V] {
+	if node == nil {
+		return &Node[K, V]{Key: key, Value: value, Height: 1, Size: 1}
+	}
+
+	if c := m.compare(key, node.Key); c > 0 {
+		node.Right = m.upsert(node.Right, key, value, previous, found)
+	} else if c < 0 {
+		node.Left = m.upsert(node.Left, key, value, previous, found)
+	} else {
+		*previous, *found = node.Value, true
+		node.Value = value
+		return node
+	}
+
+	return rebalance(node)
+}
+
+func (m *OrderedMap[K, V]) Erase(key K) {
+	var erased bool
+	if m.bst = m.erase(m.bst, key, &erased); erased {
+		m.size -= 1
+		m.version += 1
+	}
+}
+
+func (m *OrderedMap[K, V]) erase(node *Node[K, V], key K, erased *bool) *Node[K, V] {
+	if node == nil {
+		return nil
+	}
+
+	if c := m.compare(key, node.Key); c > 0 {
+		node.Right = m.erase(node.Right, key, erased)
+	} else if c < 0 {
+		node.Left = m.erase(node.Left, key, erased)
+	} else {
+		*erased = true
+		if node.Left == nil {
+			return node.Right
+		}
+		if node.Right == nil {
+			return node.Left
+		}
+
+		var min *Node[K, V]
+		node.Right, min = eraseMin(node.Right)
+		min.Left, min.Right = node.Left, node.Right
+		node = min
+	}
+
+	return rebalance(node)
+}
+
+func (m *OrderedMap[K, V]) find(key K) *Node[K, V] {
+	next := m.bst
+	for next != nil {
+		if c := m.compare(key, next.Key); c > 0 {
+			next = next.Right
+		} else if c < 0 {
+			next = next.Left
+		} else {
+			return next
+		}
+	}
+
+	return nil
+}
+
+func (m *OrderedMap[K, V]) Contains(key K) bool {
+	return m.find(key) != nil
+}
+
+func (m *OrderedMap[K, V]) Get(key K) (V, bool) {
+	if node := m.find(key); node != nil {
+		return node.Value, true
+	}
+
+	var zero V
+	return zero, false
+}
+
+func (m *OrderedMap[K, V]) GetOrDefault(key K, defaultValue V) V {
+	if node := m.find(key); node != nil {
+		return node.Value
+	}
+
+	return defaultValue
+}
+
+func (m *OrderedMap[K, V]) ForEachDescending(action func(K, V)) {
+	reverseInorderTraverse(m.bst, action)
+}
+
+// Range visits the keys in [lo, hi) in ascending order until action returns
+// false. Subtrees outside of the range are skipped
+func (m *OrderedMap[K, V]) Range(lo, hi K, action func(K, V) bool) {
+	m.rangeAscending(m.bst, lo, hi, action)
+}
+
+// RangeDescending visits the keys in [lo, hi) in descending order until
+// action returns false
+func (m *OrderedMap[K, V]) RangeDescending(lo, hi K, action func(K, V) bool) {
+	m.rangeDescending(m.bst, lo, hi, action)
+}
+
+func (m *OrderedMap[K, V]) rangeAscending(node *Node[K, V], lo, hi K, action func(K, V) bool) bool {
+	if node == nil {
+		return true
+	}
+
+	afterLo := m.compare(node.Key, lo) >= 0
+	beforeHi := m.compare(node.Key, hi) < 0
+
+	if afterLo && !m.rangeAscending(node.Left, lo, hi, action) {
+		return false
+	}
+	if afterLo && beforeHi && !action(node.Key, node.Value) {
+		return false
+	}
+	if beforeHi {
+		return m.rangeAscending(node.Right, lo, hi, action)
+	}
+
+	return true
+}
+
+func (m *OrderedMap[K, V]) rangeDescending(node *Node[K, V], lo, hi K, action func(K, V) bool) bool {
+	if node == nil {
+		return true
+	}
+
+	afterLo := m.compare(node.Key, lo) >= 0
+	beforeHi := m.compare(node.Key, hi) < 0
+
+	if beforeHi && !m.rangeDescending(node.Right, lo, hi, action) {
+		return false
+	}
+	if afterLo && beforeHi && !action(node.Key, node.Value) {
+		return false
+	}
+	if afterLo {
+		return m.rangeDescending(node.Left, lo, hi, action)
+	}
+
+	return true
+}
+
+func (m *OrderedMap[K, V]) Min() (K, V, bool) {
+	node := m.bst
+	for node != nil && node.Left != nil {
+		node = node.Left
+	}
+
+	return entry(node)
+}
+
+func (m *OrderedMap[K, V]) Max() (K, V, bool) {
+	node := m.bst
+	for node != nil && node.Right != nil {
+		node = node.Right
+	}
+
+	return entry(node)
+}
+
+// Floor returns the entry with the greatest key less than or equal to key
+func (m *OrderedMap[K, V]) Floor(key K) (K, V, bool) {
+	return entry(m.floor(key, true))
+}
+
+// Lower returns the entry with the greatest key strictly less than key
+func (m *OrderedMap[K, V]) Lower(key K) (K, V, bool) {
+	return entry(m.floor(key, false))
+}
+
+// Ceiling returns the entry with the least key greater than or equal to key
+func (m *OrderedMap[K, V]) Ceiling(key K) (K, V, bool) {
+	return entry(m.ceiling(key, true))
+}
+
+// Higher returns the entry with the least key strictly greater than key
+func (m *OrderedMap[K, V]) Higher(key K) (K, V, bool) {
+	return entry(m.ceiling(key, false))
+}
+
+func (m *OrderedMap[K, V]) PopMin() (K, V, bool) {
+	key, value, found := m.Min()
+	if found {
+		m.Erase(key)
+	}
+
+	return key, value, found
+}
+
+func (m *OrderedMap[K, V]) PopMax() (K, V, bool) {
+	key, value, found := m.Max()
+	if found {
+		m.Erase(key)
+	}
+
+	return key, value, found
+}
+
+func (m *OrderedMap[K, V]) floor(key K, inclusive bool) *Node[K, V] {
+	var result *Node[K, V]
+	for node := m.bst; node != nil; {
+		if c := m.compare(node.Key, key); c < 0 || c == 0 && inclusive {
+			result = node
+			node = node.Right
+		} else {
+			node = node.Left
+		}
+	}
+
+	return result
+}
+
+func (m *OrderedMap[K, V]) ceiling(key K, inclusive bool) *Node[K, V] {
+	var result *Node[K, V]
+	for node := m.bst; node != nil; {
+		if c := m.compare(node.Key, key); c > 0 || c == 0 && inclusive {
+			result = node
+			node = node.Left
+		} else {
+			node = node.Right
+		}
+	}
+
+	return result
+}
+
+// Rank returns the number of keys less than key
+func (m *OrderedMap[K, V]) Rank(key K) int {
+	rank := 0
+	for node := m.bst; node != nil; {
+		if c := m.compare(key, node.Key); c > 0 {
+			rank += subtreeSize(node.Left) + 1
+			node = node.Right
+		} else if c < 0 {
+			node = node.Left
+		} else {
+			return rank + subtreeSize(node.Left)
+		}
+	}
+
+	return rank
+}
+
+// Select returns the entry with the i-th smallest key, counting from zero
+func (m *OrderedMap[K, V]) Select(i int) (K, V, bool) {
+	if i < 0 || i >= m.size {
+		return entry[K, V](nil)
+	}
+
+	node := m.bst
+	for {
+		leftSize := subtreeSize(node.Left)
+		if i < leftSize {
+			node = node.Left
+		} else if i > leftSize {
+			i -= leftSize + 1
+			node = node.Right
+		} else {
+			return entry(node)
+		}
+	}
+}
+
+// CountRange returns the number of keys in [lo, hi)
+func (m *OrderedMap[K, V]) CountRange(lo, hi K) int {
+	return max(m.Rank(hi)-m.Rank(lo), 0)
+}
+
+// Validate checks the BST ordering, the AVL balance, the cached heights and
+// subtree sizes and the map size. It is meant for tests and debugging
+func (m *OrderedMap[K, V]) Validate() error {
+	size, err := m.validate(m.bst, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	if size != m.size {
+		return fmt.Errorf("map size is %d, but the tree has %d nodes", m.size, size)
+	}
+
+	return nil
+}
+
+func (m *OrderedMap[K, V]) validate(node *Node[K, V], lo, hi *K) (int, error) {
+	if node == nil {
+		return 0, nil
+	}
+
+	if lo != nil && m.compare(node.Key, *lo) <= 0 || hi != nil && m.compare(node.Key, *hi) >= 0 {
+		return 0, fmt.Errorf("key %v is out of order", node.Key)
+	}
+
+	leftSize, err := m.validate(node.Left, lo, &node.Key)
+	if err != nil {
+		return 0, err
+	}
+	rightSize, err := m.validate(node.Right, &node.Key, hi)
+	if err != nil {
+		return 0, err
+	}
+
+	leftHeight, rightHeight := height(node.Left), height(node.Right)
+	if node.Height != 1+max(leftHeight, rightHeight) {
+		return 0, fmt.Errorf("node %v has height %d, expected %d", node.Key, node.Height, 1+max(leftHeight, rightHeight))
+	}
+	if leftHeight-rightHeight > 1 || rightHeight-leftHeight > 1 {
+		return 0, fmt.Errorf("node %v is unbalanced: left height %d, right height %d", node.Key, leftHeight, rightHeight)
+	}
+
+	size := 1 + leftSize + rightSize
+	if node.Size != size {
+		return 0, fmt.Errorf("node %v has size %d, expected %d", node.Key, node.Size, size)
+	}
+
+	return size, nil
+}
+
+func entry[K, V any](node *Node[K, V]) (K, V, bool) {
+	if node == nil {
+		var (
+			key   K
+			value V
+		)
+		return key, value, false
+	}
+
+	return node.Key, node.Value, true
+}
+
+func (m *OrderedMap[K, V]) Size() int {
+	return m.size
+}
+
+func (m *OrderedMap[K, V]) ForEach(action func(K, V)) {
+	inorderTraverse(m.bst, action)
+}
+
+var (
+	ErrLengthMismatch = errors.New("keys and values have different lengths")
+	ErrNotSorted      = errors.New("keys are not strictly increasing")
+)
+
+// FromSorted builds a perfectly balanced map in O(n) from strictly
+// increasing keys and their values
+func FromSorted[K cmp.Ordered, V any](keys []K, values []V) (OrderedMap[K, V], error) {
+	return FromSortedFunc(cmp.Compare[K], keys, values)
+}
+
+// FromSortedFunc returns an empty map without a comparator on error
+func FromSortedFunc[K, V any](compare func(K, K) int, keys []K, values []V) (OrderedMap[K, V], error) {
+	if len(keys) != len(values) {
+		return OrderedMap[K, V]{}, ErrLengthMismatch
+	}
+
+	for i := 1; i < len(keys); i++ {
+		if compare(keys[i-1], keys[i]) >= 0 {
+			return OrderedMap[K, V]{}, fmt.Errorf("%w: %v goes after %v", ErrNotSorted, keys[i], keys[i-1])
+		}
+	}
+
+	return fromSorted(compare, keys, values), nil
+}
+
+func fromSorted[K, V any](compare func(K, K) int, keys []K, values []V) OrderedMap[K, V] {
+	m := NewOrderedMapFunc[K, V](compare)
+	m.bst = buildBalanced(keys, values)
+	m.size = len(keys)
+
+	return m
+}
+
+func buildBalanced[K, V any](keys []K, values []V) *Node[K, V] {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	middle := len(keys) / 2
+	node := &Node[K, V]{
+		Key:   keys[middle],
+		Value: values[middle],
+		Left:  buildBalanced(keys[:middle], values[:middle]),
+		Right: buildBalanced(keys[middle+1:], values[middle+1:]),
+	}
+	node.update()
+
+	return node
+}
+
+func (m *OrderedMap[K, V]) entries() ([]K, []V) {
+	keys := make([]K, 0, m.size)
+	values := make([]V, 0, m.size)
+	m.ForEach(func(key K, value V) {
+		keys = append(keys, key)
+		values = append(values, value)
+	})
+
+	return keys, values
+}
+
+// mergeEntries passes every key of both maps to add, see mergeSorted
+func (m *OrderedMap[K, V]) mergeEntries(other *OrderedMap[K, V], add func(key K, value, otherValue *V)) {
+	keys, values := m.entries()
+	otherKeys, otherValues := other.entries()
+
+	mergeSorted(m.compare, keys, values, otherKeys, otherValues, add)
+}
+
+// mergeSorted walks two sorted sets of entries in O(n+m) and passes each key
+// to add together with its values from the first and the second, if present
+func mergeSorted[K, V any](compare func(K, K) int, keys []K, values []V, otherKeys []K, otherValues []V, add func(key K, value, otherValue *V)) {
+	i, j := 0, 0
+	for i < len(keys) || j < len(otherKeys) {
+		c := 0
+		switch {
+		case i == len(keys):
+			c = 1
+		case j == len(otherKeys):
+			c = -1
+		default:
+			c = compare(keys[i], otherKeys[j])
+		}
+
+		switch {
+		case c < 0:
+			add(keys[i], &values[i], nil)
+			i++
+		case c > 0:
+			add(otherKeys[j], nil, &otherValues[j])
+			j++
+		default:
+			add(keys[i], &values[i], &otherValues[j])
+			i++
+			j++
+		}
+	}
+}
+
+func (m *OrderedMap[K, V]) collect(other *OrderedMap[K, V], pick func(key K, value, otherValue *V) *V) OrderedMap[K, V] {
+	var (
+		keys   []K
+		values []V
+	)
+	m.mergeEntries(other, func(key K, value, otherValue *V) {
+		if picked := pick(key, value, otherValue); picked != nil {
+			keys = append(keys, key)
+			values = append(values, *picked)
+		}
+	})
+
+	// an empty zero value m has no comparator yet, other has one if it
+	// contributed any keys
+	compare := m.compare
+	if compare == nil {
+		compare = other.compare
+	}
+
+	return fromSorted(compare, keys, values)
+}
+
+// Merge adds all entries of other to m in O(n+m). For keys present in both
+// maps the value is resolved by conflict, or taken from other if it is nil
+func (m *OrderedMap[K, V]) Merge(other *OrderedMap[K, V], conflict func(key K, value, otherValue V) V) {
+	merged := m.collect(other, func(key K, value, otherValue *V) *V {
+		if value != nil && otherValue != nil && conflict != nil {
+			resolved := conflict(key, *value, *otherValue)
+			return &resolved
+		}
+		if otherValue != nil {
+			return otherValue
+		}
+		return value
+	})
+
+	m.bst, m.size = merged.bst, merged.size
+	m.version += 1
+}
+
+// Split returns the entries with keys less than key and the rest as two
+// new maps, leaving m unchanged
+func (m *OrderedMap[K, V]) Split(key K) (OrderedMap[K, V], OrderedMap[K, V]) {
+	keys, values := m.entries()
+	index := m.Rank(key)
+
+	return fromSorted(m.compare, keys[:index], values[:index]), fromSorted(m.compare, keys[index:], values[index:])
+}
+
+// Union returns the keys of both maps, preferring values from m
+func (m *OrderedMap[K, V]) Union(other *OrderedMap[K, V]) OrderedMap[K, V] {
+	return m.collect(other, func(_ K, value, otherValue *V) *V {
+		if value != nil {
+			return value
+		}
+		return otherValue
+	})
+}
+
+// Intersection returns the keys present in both maps with values from m
+func (m *OrderedMap[K, V]) Intersection(other *OrderedMap[K, V]) OrderedMap[K, V] {
+	return m.collect(other, func(_ K, value, otherValue *V) *V {
+		if otherValue == nil {
+			return nil
+		}
+		return value
+	})
 }
 
-func inorderTraverse(node *Node, action func(int, int)) {
-	if node == nil {
-		return
-	}
+// Difference returns the keys of m that are not present in other
+func (m *OrderedMap[K, V]) Difference(other *OrderedMap[K, V]) OrderedMap[K, V] {
+	return m.collect(other, func(_ K, value, otherValue *V) *V {
+		if otherValue != nil {
+			return nil
+		}
+		return value
+	})
+}
 
-	inorderTraverse(node.Left, action)
-	action(node.Key, node.Value)
-	inorderTraverse(node.Right, action)
+// Iterator walks an OrderedMap in both directions without recursion. It
+// keeps the path from the root to the current node. If the map has been
+// modified since the iterator was positioned, Next and Prev seek to the
+// neighbour of the current key in the updated map, so iteration continues
+// where it stopped
+type Iterator[K, V any] struct {
+	m       *OrderedMap[K, V]
+	path    []*Node[K, V]
+	version int
 }
 
-type OrderedMap struct {
-	bst *Node
+func (m *OrderedMap[K, V]) Iterator() *Iterator[K, V] {
+	return &Iterator[K, V]{m: m}
+}
 
-	size int
+func (it *Iterator[K, V]) Valid() bool {
+	return len(it.path) > 0
 }
 
-func NewOrderedMap() OrderedMap {
-	return OrderedMap{
-		size: 0,
-	}
+// Key and Value must only be called on a valid iterator
+func (it *Iterator[K, V]) Key() K {
+	return it.path[len(it.path)-1].Key
 }
 
-func (m *OrderedMap) Insert(key, value int) {
-	if m.bst == nil {
-		m.bst = &Node{
-			Key:   key,
-			Value: value,
-		}
-	} else {
-		curr := m.bst
-		for curr != nil {
-			if key > curr.Key {
-				if curr.Right == nil {
-					curr.Right = &Node{Key: key, Value: value}
-					break
-				}
-				curr = curr.Right
-			} else if key < curr.Key {
-				if curr.Left == nil {
-					curr.Left = &Node{Key: key, Value: value}
-					break
-				}
-				curr = curr.Left
-			} else {
-				curr.Value = value
-				return
-			}
-		}
+func (it *Iterator[K, V]) Value() V {
+	return it.path[len(it.path)-1].Value
+}
+
+func (it *Iterator[K, V]) First() bool {
+	it.reset()
+	for node := it.m.bst; node != nil; node = node.Left {
+		it.path = append(it.path, node)
 	}
 
-	m.size += 1
+	return it.Valid()
 }
 
-func (m *OrderedMap) Erase(key int) {
-	var (
-		curr, parent *Node = m.bst, nil
-	)
-	for curr != nil && curr.Key != key {
-		parent = curr
-		if key > curr.Key {
-			curr = curr.Right
-		} else if key < curr.Key {
-			curr = curr.Left
-		}
+func (it *Iterator[K, V]) Last() bool {
+	it.reset()
+	for node := it.m.bst; node != nil; node = node.Right {
+		it.path = append(it.path, node)
 	}
 
-	if curr == nil {
-		return
-	}
+	return it.Valid()
+}
 
-	m.size -= 1
+// Seek positions the iterator at the least key greater than or equal to key
+func (it *Iterator[K, V]) Seek(key K) bool {
+	return it.seek(key, true, true)
+}
 
-	if curr.Right == nil && curr.Left == nil {
-		if parent == nil {
-			m.bst = nil
-		} else if parent.Left == curr {
-			parent.Left = nil
-		} else {
-			parent.Right = nil
-		}
-		return
+func (it *Iterator[K, V]) Next() bool {
+	if !it.Valid() {
+		return false
+	}
+	if it.version != it.m.version {
+		return it.seek(it.Key(), false, true)
 	}
 
-	if curr.Right == nil || curr.Left != nil {
-		child := &Node{}
-		if curr.Left != nil {
-			child = curr.Left
-		} else {
-			child = curr.Right
+	if node := it.path[len(it.path)-1].Right; node != nil {
+		for ; node != nil; node = node.Left {
+			it.path = append(it.path, node)
 		}
+		return true
+	}
 
-		if parent == nil {
-			m.bst = child
-		} else if parent.Left == curr {
-			parent.Left = child
-		} else {
-			parent.Right = child
+	for {
+		child := it.path[len(it.path)-1]
+		it.path = it.path[:len(it.path)-1]
+		if !it.Valid() || it.path[len(it.path)-1].Left == child {
+			return it.Valid()
 		}
+	}
+}
 
-		return
+func (it *Iterator[K, V]) Prev() bool {
+	if !it.Valid() {
+		return false
+	}
+	if it.version != it.m.version {
+		return it.seek(it.Key(), false, false)
 	}
 
-	var (
-		min, minParent *Node = curr.Right, curr
-	)
-	for min.Left != nil {
-		minParent = min
-		min = min.Left
+	if node := it.path[len(it.path)-1].Left; node != nil {
+		for ; node != nil; node = node.Right {
+			it.path = append(it.path, node)
+		}
+		return true
 	}
 
-	curr.Key = min.Key
-	curr.Value = min.Value
-	if minParent.Left == min {
-		minParent.Left = min.Right
-	} else {
-		minParent.Right = min.Right
+	for {
+		child := it.path[len(it.path)-1]
+		it.path = it.path[:len(it.path)-1]
+		if !it.Valid() || it.path[len(it.path)-1].Right == child {
+			return it.Valid()
+		}
 	}
 }
 
-func (m *OrderedMap) Contains(key int) bool {
-	next := m.bst
-	for next != nil {
-		if key > next.Key {
-			next = next.Right
-		} else if key < next.Key {
-			next = next.Left
+// seek finds the closest key after (ascending) or before the given one and
+// keeps the search path up to it
+func (it *Iterator[K, V]) seek(key K, inclusive, ascending bool) bool {
+	it.reset()
+
+	found := 0
+	for node := it.m.bst; node != nil; {
+		it.path = append(it.path, node)
+
+		c := it.m.compare(node.Key, key)
+		if c == 0 && inclusive {
+			found = len(it.path)
+			break
+		}
+		if ascending && c > 0 || !ascending && c < 0 {
+			found = len(it.path)
+		}
+
+		if c > 0 || c == 0 && !ascending {
+			node = node.Left
 		} else {
-			return true
+			node = node.Right
 		}
 	}
+	it.path = it.path[:found]
 
-	return false
+	return it.Valid()
 }
 
-func (m *OrderedMap) Size() int {
-	return m.size
-}
-
-func (m *OrderedMap) ForEach(action func(int, int)) {
-	inorderTraverse(m.bst, action)
+func (it *Iterator[K, V]) reset() {
+	it.path = it.path[:0]
+	it.version = it.m.version
 }
 
 func TestCircularQueue(t *testing.T) {
-	data := NewOrderedMap()
+	data := NewOrderedMap[int, int]()
 	assert.Zero(t, data.Size())
 
 	data.Insert(10, 10)
@@ -203,3 +947,646 @@ func TestCircularQueue(t *testing.T) {
 
 	assert.True(t, reflect.DeepEqual(expectedKeys, keys))
 }
+
+func TestOrderedMapBalance(t *testing.T) {
+	const keysNumber = 1 << 12
+	data := NewOrderedMap[int, int]()
+	for i := 0; i < keysNumber; i++ {
+		data.Insert(i, i)
+	}
+
+	maxHeight := int(1.45 * math.Log2(keysNumber+2))
+	assert.Equal(t, keysNumber, data.Size())
+	assert.LessOrEqual(t, height(data.bst), maxHeight)
+
+	for i := 0; i < keysNumber; i += 2 {
+		data.Erase(i)
+	}
+
+	assert.Equal(t, keysNumber/2, data.Size())
+	assert.LessOrEqual(t, height(data.bst), maxHeight)
+
+	expected := 1
+	data.ForEach(func(key, _ int) {
+		assert.Equal(t, expected, key)
+		expected += 2
+	})
+	assert.Equal(t, keysNumber+1, expected)
+}
+
+const benchmarkKeysNumber = 1 << 14
+
+func benchmarkInsert(b *testing.B, keys []int) {
+	for i := 0; i < b.N; i++ {
+		data := NewOrderedMap[int, int]()
+		for _, key := range keys {
+			data.Insert(key, key)
+		}
+	}
+}
+
+func benchmarkContains(b *testing.B, keys []int) {
+	data := NewOrderedMap[int, int]()
+	for _, key := range keys {
+		data.Insert(key, key)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		data.Contains(keys[i%len(keys)])
+	}
+}
+
+func sortedKeys() []int {
+	keys := make([]int, benchmarkKeysNumber)
+	for i := range keys {
+		keys[i] = i
+	}
+
+	return keys
+}
+
+func randomKeys() []int {
+	return rand.New(rand.NewSource(1)).Perm(benchmarkKeysNumber)
+}
+
+func BenchmarkOrderedMapInsertSorted(b *testing.B) {
+	benchmarkInsert(b, sortedKeys())
+}
+
+func BenchmarkOrderedMapInsertRandom(b *testing.B) {
+	benchmarkInsert(b, randomKeys())
+}
+
+func BenchmarkOrderedMapContainsSorted(b *testing.B) {
+	benchmarkContains(b, sortedKeys())
+}
+
+func BenchmarkOrderedMapContainsRandom(b *testing.B) {
+	benchmarkContains(b, randomKeys())
+}
+
+func TestOrderedMapGet(t *testing.T) {
+	data := NewOrderedMap[string, int]()
+
+	value, found := data.Get("a")
+	assert.False(t, found)
+	assert.Zero(t, value)
+	assert.Equal(t, 42, data.GetOrDefault("a", 42))
+
+	previous, found := data.Upsert("a", 1)
+	assert.False(t, found)
+	assert.Zero(t, previous)
+
+	previous, found = data.Upsert("a", 2)
+	assert.True(t, found)
+	assert.Equal(t, 1, previous)
+	assert.Equal(t, 1, data.Size())
+
+	data.Insert("c", 3)
+	data.Insert("b", 4)
+
+	value, found = data.Get("a")
+	assert.True(t, found)
+	assert.Equal(t, 2, value)
+	assert.Equal(t, 4, data.GetOrDefault("b", 42))
+
+	data.Erase("a")
+	_, found = data.Get("a")
+	assert.False(t, found)
+	assert.Equal(t, 2, data.Size())
+}
+
+func TestOrderedMapComparator(t *testing.T) {
+	type point struct {
+		x, y int
+	}
+
+	data := NewOrderedMapFunc[point, string](func(a, b point) int {
+		if c := cmp.Compare(a.x, b.x); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.y, b.y)
+	})
+
+	data.Insert(point{2, 1}, "c")
+	data.Insert(point{1, 5}, "b")
+	data.Insert(point{1, 2}, "a")
+	data.Insert(point{1, 5}, "B")
+
+	assert.Equal(t, 3, data.Size())
+	assert.Equal(t, "B", data.GetOrDefault(point{1, 5}, ""))
+
+	var values []string
+	data.ForEach(func(_ point, value string) {
+		values = append(values, value)
+	})
+	assert.Equal(t, []string{"a", "B", "c"}, values)
+
+	caseInsensitive := NewOrderedMapFunc[string, int](func(a, b string) int {
+		return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+	})
+	caseInsensitive.Insert("Key", 1)
+	assert.True(t, caseInsensitive.Contains("KEY"))
+}
+
+func TestOrderedMapNavigation(t *testing.T) {
+	data := NewOrderedMap[int, string]()
+
+	_, _, found := data.Min()
+	assert.False(t, found)
+	_, _, found = data.Max()
+	assert.False(t, found)
+	_, _, found = data.Floor(10)
+	assert.False(t, found)
+	_, _, found = data.PopMin()
+	assert.False(t, found)
+
+	for _, key := range []int{10, 20, 30, 40} {
+		data.Insert(key, strconv.Itoa(key))
+	}
+
+	key, value, found := data.Min()
+	assert.True(t, found)
+	assert.Equal(t, 10, key)
+	assert.Equal(t, "10", value)
+
+	key, _, _ = data.Max()
+	assert.Equal(t, 40, key)
+
+	tests := map[string]struct {
+		query    func(int) (int, string, bool)
+		key      int
+		expected int
+		found    bool
+	}{
+		"floor exact":       {query: data.Floor, key: 20, expected: 20, found: true},
+		"floor between":     {query: data.Floor, key: 25, expected: 20, found: true},
+		"floor below min":   {query: data.Floor, key: 5},
+		"lower exact":       {query: data.Lower, key: 20, expected: 10, found: true},
+		"lower min":         {query: data.Lower, key: 10},
+		"ceiling exact":     {query: data.Ceiling, key: 30, expected: 30, found: true},
+		"ceiling between":   {query: data.Ceiling, key: 25, expected: 30, found: true},
+		"ceiling above max": {query: data.Ceiling, key: 45},
+		"higher exact":      {query: data.Higher, key: 30, expected: 40, found: true},
+		"higher max":        {query: data.Higher, key: 40},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			key, _, found := test.query(test.key)
+			assert.Equal(t, test.found, found)
+			assert.Equal(t, test.expected, key)
+		})
+	}
+
+	key, value, found = data.PopMin()
+	assert.True(t, found)
+	assert.Equal(t, 10, key)
+	assert.Equal(t, "10", value)
+
+	key, _, found = data.PopMax()
+	assert.True(t, found)
+	assert.Equal(t, 40, key)
+
+	assert.Equal(t, 2, data.Size())
+	assert.False(t, data.Contains(10))
+	assert.False(t, data.Contains(40))
+}
+
+func TestOrderedMapRange(t *testing.T) {
+	data := NewOrderedMap[int, int]()
+	for i := 0; i < 100; i += 5 {
+		data.Insert(i, i*i)
+	}
+
+	collect := func(keys *[]int, limit int) func(int, int) bool {
+		return func(key, value int) bool {
+			assert.Equal(t, key*key, value)
+			*keys = append(*keys, key)
+			return len(*keys) < limit
+		}
+	}
+
+	var keys []int
+	data.Range(12, 35, collect(&keys, math.MaxInt))
+	assert.Equal(t, []int{15, 20, 25, 30}, keys)
+
+	keys = nil
+	data.Range(15, 30, collect(&keys, math.MaxInt))
+	assert.Equal(t, []int{15, 20, 25}, keys)
+
+	keys = nil
+	data.Range(0, 100, collect(&keys, 3))
+	assert.Equal(t, []int{0, 5, 10}, keys)
+
+	keys = nil
+	data.Range(30, 30, collect(&keys, math.MaxInt))
+	assert.Empty(t, keys)
+
+	keys = nil
+	data.Range(200, 300, collect(&keys, math.MaxInt))
+	assert.Empty(t, keys)
+
+	keys = nil
+	data.RangeDescending(12, 35, collect(&keys, math.MaxInt))
+	assert.Equal(t, []int{30, 25, 20, 15}, keys)
+
+	keys = nil
+	data.RangeDescending(0, 100, collect(&keys, 2))
+	assert.Equal(t, []int{95, 90}, keys)
+
+	visited := 0
+	data.Range(0, 100, func(int, int) bool {
+		visited++
+		return false
+	})
+	assert.Equal(t, 1, visited)
+
+	keys = nil
+	data.ForEachDescending(func(key, _ int) {
+		keys = append(keys, key)
+	})
+	assert.Len(t, keys, data.Size())
+	assert.Equal(t, 95, keys[0])
+	assert.Equal(t, 0, keys[len(keys)-1])
+}
+
+func TestOrderedMapIterator(t *testing.T) {
+	data := NewOrderedMap[int, int]()
+	it := data.Iterator()
+	assert.False(t, it.First())
+	assert.False(t, it.Last())
+	assert.False(t, it.Seek(0))
+	assert.False(t, it.Next())
+	assert.False(t, it.Prev())
+
+	for i := 1; i <= 50; i++ {
+		data.Insert(i*2, i)
+	}
+
+	var keys []int
+	for ok := it.First(); ok; ok = it.Next() {
+		assert.Equal(t, it.Key()/2, it.Value())
+		keys = append(keys, it.Key())
+	}
+	assert.Len(t, keys, 50)
+	assert.Equal(t, 2, keys[0])
+	assert.Equal(t, 100, keys[49])
+
+	keys = nil
+	for ok := it.Last(); ok; ok = it.Prev() {
+		keys = append(keys, it.Key())
+	}
+	assert.Len(t, keys, 50)
+	assert.Equal(t, 100, keys[0])
+	assert.Equal(t, 2, keys[49])
+
+	assert.True(t, it.Seek(31))
+	assert.Equal(t, 32, it.Key())
+	assert.True(t, it.Seek(32))
+	assert.Equal(t, 32, it.Key())
+	assert.True(t, it.Prev())
+	assert.Equal(t, 30, it.Key())
+	assert.True(t, it.Next())
+	assert.True(t, it.Next())
+	assert.Equal(t, 34, it.Key())
+	assert.False(t, it.Seek(101))
+
+	assert.True(t, it.Seek(50))
+	data.Erase(52)
+	data.Insert(51, 0)
+	assert.True(t, it.Next())
+	assert.Equal(t, 51, it.Key())
+	data.Erase(50)
+	assert.True(t, it.Prev())
+	assert.Equal(t, 48, it.Key())
+
+	assert.True(t, it.Last())
+	data.Erase(100)
+	assert.False(t, it.Next())
+}
+
+func TestOrderedMapMergeJoin(t *testing.T) {
+	left := NewOrderedMap[int, string]()
+	right := NewOrderedMap[int, string]()
+	for i := 0; i < 30; i += 2 {
+		left.Insert(i, "l")
+	}
+	for i := 0; i < 30; i += 3 {
+		right.Insert(i, "r")
+	}
+
+	var joined []int
+	l, r := left.Iterator(), right.Iterator()
+	lok, rok := l.First(), r.First()
+	for lok && rok {
+		switch {
+		case l.Key() < r.Key():
+			lok = l.Next()
+		case l.Key() > r.Key():
+			rok = r.Next()
+		default:
+			joined = append(joined, l.Key())
+			lok, rok = l.Next(), r.Next()
+		}
+	}
+
+	assert.Equal(t, []int{0, 6, 12, 18, 24}, joined)
+}
+
+func TestOrderedMapOrderStatistics(t *testing.T) {
+	data := NewOrderedMap[int, int]()
+	assert.Zero(t, data.Rank(10))
+	_, _, found := data.Select(0)
+	assert.False(t, found)
+
+	keys := rand.New(rand.NewSource(1)).Perm(200)
+	for _, key := range keys {
+		data.Insert(key*10, key)
+	}
+	for key := 0; key < 200; key += 3 {
+		data.Erase(key * 10)
+	}
+
+	var sorted []int
+	data.ForEach(func(key, _ int) {
+		sorted = append(sorted, key)
+	})
+	assert.Equal(t, len(sorted), subtreeSize(data.bst))
+
+	for i, key := range sorted {
+		assert.Equal(t, i, data.Rank(key))
+		assert.Equal(t, i, data.Rank(key-1))
+		assert.Equal(t, i+1, data.Rank(key+1))
+
+		selected, value, found := data.Select(i)
+		assert.True(t, found)
+		assert.Equal(t, key, selected)
+		assert.Equal(t, key/10, value)
+	}
+
+	_, _, found = data.Select(-1)
+	assert.False(t, found)
+	_, _, found = data.Select(len(sorted))
+	assert.False(t, found)
+
+	assert.Equal(t, len(sorted), data.CountRange(math.MinInt, math.MaxInt))
+	assert.Equal(t, 4, data.CountRange(10, 60))
+	assert.Equal(t, 5, data.CountRange(10, 71))
+	assert.Zero(t, data.CountRange(60, 10))
+}
+
+func TestOrderedMapEraseNodeWithTwoChildren(t *testing.T) {
+	data := NewOrderedMap[int, int]()
+	for _, key := range []int{10, 5, 15, 3, 7, 12, 20} {
+		data.Insert(key, key)
+	}
+
+	data.Erase(10)
+	data.Erase(5)
+
+	assert.NoError(t, data.Validate())
+	assert.Equal(t, 5, data.Size())
+	for _, key := range []int{3, 7, 12, 15, 20} {
+		assert.True(t, data.Contains(key))
+	}
+}
+
+func TestOrderedMapValidate(t *testing.T) {
+	data := NewOrderedMap[int, int]()
+	assert.NoError(t, data.Validate())
+
+	for i := 0; i < 10; i++ {
+		data.Insert(i, i)
+	}
+	assert.NoError(t, data.Validate())
+
+	data.size += 1
+	assert.ErrorContains(t, data.Validate(), "map size")
+	data.size -= 1
+
+	data.bst.Left.Key, data.bst.Right.Key = data.bst.Right.Key, data.bst.Left.Key
+	assert.ErrorContains(t, data.Validate(), "out of order")
+	data.bst.Left.Key, data.bst.Right.Key = data.bst.Right.Key, data.bst.Left.Key
+
+	data.bst.Size += 1
+	assert.ErrorContains(t, data.Validate(), "has size")
+	data.bst.Size -= 1
+
+	right := data.bst.Right
+	data.bst.Right = nil
+	data.bst.update()
+	assert.ErrorContains(t, data.Validate(), "unbalanced")
+	data.bst.Right = right
+	data.bst.update()
+
+	data.bst.Height += 1
+	assert.ErrorContains(t, data.Validate(), "has height")
+	data.bst.Height -= 1
+
+	assert.NoError(t, data.Validate())
+}
+
+func TestOrderedMapDifferential(t *testing.T) {
+	const operationsNumber = 20_000
+	const keysRange = 500
+
+	random := rand.New(rand.NewSource(42))
+	data := NewOrderedMap[int, int]()
+	reference := make(map[int]int)
+
+	for i := 0; i < operationsNumber; i++ {
+		key := random.Intn(keysRange)
+
+		switch operation := random.Intn(10); {
+		case operation < 5:
+			data.Insert(key, i)
+			reference[key] = i
+		case operation < 8:
+			data.Erase(key)
+			delete(reference, key)
+		default:
+			_, _, _ = data.PopMin()
+			if len(reference) > 0 {
+				keys := sortedReferenceKeys(reference)
+				delete(reference, keys[0])
+			}
+		}
+
+		value, found := data.Get(key)
+		expected, expectedFound := reference[key]
+		if !assert.Equal(t, expectedFound, found) || !assert.Equal(t, expected, value) {
+			t.FailNow()
+		}
+
+		if i%100 == 0 {
+			if err := data.Validate(); err != nil {
+				t.Fatalf("operation %d: %v", i, err)
+			}
+
+			keys := sortedReferenceKeys(reference)
+			actual := make([]int, 0, data.Size())
+			data.ForEach(func(key, value int) {
+				assert.Equal(t, reference[key], value)
+				actual = append(actual, key)
+			})
+			if !assert.Equal(t, keys, actual) {
+				t.FailNow()
+			}
+
+			probe := random.Intn(keysRange)
+			index, _ := slices.BinarySearch(keys, probe)
+			assert.Equal(t, index, data.Rank(probe))
+		}
+	}
+
+	assert.NoError(t, data.Validate())
+	assert.Equal(t, len(reference), data.Size())
+}
+
+func sortedReferenceKeys(reference map[int]int) []int {
+	keys := make([]int, 0, len(reference))
+	for key := range reference {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	return keys
+}
+
+func TestOrderedMapFromSorted(t *testing.T) {
+	const keysNumber = 1000
+	keys := make([]int, keysNumber)
+	values := make([]string, keysNumber)
+	for i := range keys {
+		keys[i] = i * 2
+		values[i] = strconv.Itoa(i * 2)
+	}
+
+	data, err := FromSorted(keys, values)
+	assert.NoError(t, err)
+	assert.NoError(t, data.Validate())
+	assert.Equal(t, keysNumber, data.Size())
+	assert.Equal(t, int(math.Ceil(math.Log2(keysNumber+1))), height(data.bst))
+	assert.Equal(t, "998", data.GetOrDefault(998, ""))
+
+	data.Insert(1, "1")
+	assert.NoError(t, data.Validate())
+
+	empty, err := FromSorted[int, int](nil, nil)
+	assert.NoError(t, err)
+	assert.Zero(t, empty.Size())
+
+	_, err = FromSorted([]int{1, 2}, []int{1})
+	assert.ErrorIs(t, err, ErrLengthMismatch)
+	_, err = FromSorted([]int{1, 3, 3}, []int{1, 2, 3})
+	assert.ErrorIs(t, err, ErrNotSorted)
+}
+
+func TestOrderedMapMergeAndSplit(t *testing.T) {
+	first, _ := FromSorted([]int{1, 3, 5, 7}, []int{10, 30, 50, 70})
+	second, _ := FromSorted([]int{2, 3, 7, 8}, []int{2, 3, 7, 8})
+
+	collect := func(m *OrderedMap[int, int]) map[int]int {
+		assert.NoError(t, m.Validate())
+		result := make(map[int]int)
+		m.ForEach(func(key, value int) {
+			result[key] = value
+		})
+		return result
+	}
+
+	union := first.Union(&second)
+	assert.Equal(t, map[int]int{1: 10, 2: 2, 3: 30, 5: 50, 7: 70, 8: 8}, collect(&union))
+
+	intersection := first.Intersection(&second)
+	assert.Equal(t, map[int]int{3: 30, 7: 70}, collect(&intersection))
+
+	difference := first.Difference(&second)
+	assert.Equal(t, map[int]int{1: 10, 5: 50}, collect(&difference))
+
+	left, right := first.Split(5)
+	assert.Equal(t, map[int]int{1: 10, 3: 30}, collect(&left))
+	assert.Equal(t, map[int]int{5: 50, 7: 70}, collect(&right))
+	assert.Equal(t, 4, first.Size())
+
+	left, right = first.Split(100)
+	assert.Equal(t, 4, left.Size())
+	assert.Zero(t, right.Size())
+
+	merged := first
+	merged.Merge(&second, func(_ int, value, otherValue int) int {
+		return value + otherValue
+	})
+	assert.Equal(t, map[int]int{1: 10, 2: 2, 3: 33, 5: 50, 7: 77, 8: 8}, collect(&merged))
+
+	overwritten, _ := FromSorted([]int{1, 3}, []int{10, 30})
+	overwritten.Merge(&second, nil)
+	assert.Equal(t, map[int]int{1: 10, 2: 2, 3: 3, 7: 7, 8: 8}, collect(&overwritten))
+}
+
+func BenchmarkOrderedMapFromSorted(b *testing.B) {
+	keys := sortedKeys()
+	for i := 0; i < b.N; i++ {
+		_, _ = FromSorted(keys, keys)
+	}
+}
+
+func TestOrderedMapZeroValue(t *testing.T) {
+	var data OrderedMap[int, int]
+	data.Insert(2, 2)
+	data.Insert(1, 1)
+	data.Insert(3, 3)
+	assert.NoError(t, data.Validate())
+	assert.Equal(t, 3, data.Size())
+	assert.True(t, data.Contains(1))
+
+	type priority int8
+	var named OrderedMap[priority, string]
+	named.Insert(-1, "low")
+	named.Insert(5, "high")
+	named.Insert(0, "normal")
+	key, _, _ := named.Min()
+	assert.Equal(t, priority(-1), key)
+
+	failed, err := FromSorted([]int{2, 1}, []int{2, 1})
+	assert.Error(t, err)
+	failed.Insert(1, 1)
+	failed.Insert(0, 0)
+	assert.NoError(t, failed.Validate())
+
+	type point struct {
+		x, y int
+	}
+	var unordered OrderedMap[point, int]
+	assert.PanicsWithValue(t, "OrderedMap: key type main.point is not ordered, create the map with NewOrderedMapFunc", func() {
+		unordered.Insert(point{1, 2}, 1)
+	})
+	assert.Zero(t, unordered.Size())
+	assert.False(t, unordered.Contains(point{1, 2}))
+
+	type name string
+	var empty, names OrderedMap[name, int]
+	names.Insert("b", 2)
+	names.Insert("a", 1)
+	union := empty.Union(&names)
+	union.Insert("c", 3)
+	assert.NoError(t, union.Validate())
+	assert.Equal(t, 3, union.Size())
+
+	// reads must not write the comparator, run with -race
+	var single OrderedMap[int, int]
+	single.Insert(1, 1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.True(t, single.Contains(1))
+			assert.False(t, single.Contains(2))
+		}()
+	}
+	wg.Wait()
+}
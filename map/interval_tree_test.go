@@ -0,0 +1,523 @@
+package main
+
+import (
+	"cmp"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Interval is a closed interval [Start, End] with an associated value
+type Interval[T cmp.Ordered, V any] struct {
+	Start T
+	End   T
+	Value V
+}
+
+type intervalKey[T cmp.Ordered] struct {
+	Start T
+	End   T
+}
+
+type intervalEntry[T cmp.Ordered, V any] struct {
+	Value V
+	// Live is false for leaves whose interval was erased, they keep routing
+	// queries until the next rebuild
+	Live bool
+	// Slot is the leaf that ends last among the intervals routed through
+	// this node and not kept by one of its ancestors
+	Slot *Node[intervalKey[T], intervalEntry[T, V]]
+}
+
+// IntervalTree is a priority search tree of intervals. The intervals are the
+// leaves of an AVL tree ordered by (Start, End), built from the OrderedMap
+// nodes and balancing, and every node keeps in its slot the interval of its
+// subtree that ends last and is not kept higher up. Equal intervals are
+// stored once
+type IntervalTree[T cmp.Ordered, V any] struct {
+	root *Node[intervalKey[T], intervalEntry[T, V]]
+
+	size int
+	dead int
+}
+
+func NewIntervalTree[T cmp.Ordered, V any]() IntervalTree[T, V] {
+	return IntervalTree[T, V]{}
+}
+
+func compareIntervals[T cmp.Ordered](a, b intervalKey[T]) int {
+	if c := cmp.Compare(a.Start, b.Start); c != 0 {
+		return c
+	}
+
+	return cmp.Compare(a.End, b.End)
+}
+
+// endsAfter orders leaves by end and then by key, an empty slot is last
+func endsAfter[T cmp.Ordered, V any](a, b *Node[intervalKey[T], intervalEntry[T, V]]) bool {
+	if a == nil || b == nil {
+		return b == nil && a != nil
+	}
+
+	if c := cmp.Compare(a.Key.End, b.Key.End); c != 0 {
+		return c > 0
+	}
+
+	return compareIntervals(a.Key, b.Key) > 0
+}
+
+// intervalChild routes key below a router node, whose key is the greatest
+// key of its left subtree
+func intervalChild[T cmp.Ordered, V any](node *Node[intervalKey[T], intervalEntry[T, V]], key intervalKey[T]) *Node[intervalKey[T], intervalEntry[T, V]] {
+	if compareIntervals(key, node.Key) <= 0 {
+		return node.Left
+	}
+
+	return node.Right
+}
+
+// pushInterval stores leaf in the first empty slot on its route from node,
+// swapping it with every slot that ends before it on the way down
+func pushInterval[T cmp.Ordered, V any](node, leaf *Node[intervalKey[T], intervalEntry[T, V]]) {
+	for node.Value.Slot != nil {
+		if endsAfter(leaf, node.Value.Slot) {
+			node.Value.Slot, leaf = leaf, node.Value.Slot
+		}
+		node = intervalChild(node, leaf.Key)
+	}
+
+	node.Value.Slot = leaf
+}
+
+// pullInterval refills the emptied slot of node from its children
+func pullInterval[T cmp.Ordered, V any](node *Node[intervalKey[T], intervalEntry[T, V]]) {
+	for node.Left != nil {
+		child := node.Left
+		if endsAfter(node.Right.Value.Slot, child.Value.Slot) {
+			child = node.Right
+		}
+		if child.Value.Slot == nil {
+			return
+		}
+
+		node.Value.Slot, child.Value.Slot = child.Value.Slot, nil
+		node = child
+	}
+}
+
+// updateInterval is the balancing hook. A rotation leaves the old parent
+// below the new one with the slot of the whole subtree, it moves that slot
+// up and routes the displaced one down again
+func updateInterval[T cmp.Ordered, V any](node *Node[intervalKey[T], intervalEntry[T, V]]) {
+	node.update()
+
+	for _, child := range [2]*Node[intervalKey[T], intervalEntry[T, V]]{node.Left, node.Right} {
+		if child == nil || !endsAfter(child.Value.Slot, node.Value.Slot) {
+			continue
+		}
+
+		displaced := node.Value.Slot
+		node.Value.Slot, child.Value.Slot = child.Value.Slot, nil
+		pullInterval(child)
+		if displaced != nil {
+			pushInterval(intervalChild(node, displaced.Key), displaced)
+		}
+	}
+}
+
+func (t *IntervalTree[T, V]) find(key intervalKey[T]) *Node[intervalKey[T], intervalEntry[T, V]] {
+	node := t.root
+	for node != nil && node.Left != nil {
+		node = intervalChild(node, key)
+	}
+
+	if node == nil || compareIntervals(key, node.Key) != 0 {
+		return nil
+	}
+
+	return node
+}
+
+// Insert ignores intervals with start greater than end. It makes at most
+// one single or double rotation and every rotation moves O(log n) slots,
+// so it takes O(log n)
+func (t *IntervalTree[T, V]) Insert(start, end T, value V) {
+	if start > end {
+		return
+	}
+
+	key := intervalKey[T]{Start: start, End: end}
+	leaf := t.find(key)
+	if leaf != nil && leaf.Value.Live {
+		leaf.Value.Value = value
+		return
+	}
+
+	if leaf != nil {
+		leaf.Value.Value, leaf.Value.Live = value, true
+		t.dead -= 1
+	} else {
+		leaf = &Node[intervalKey[T], intervalEntry[T, V]]{
+			Key:    key,
+			Value:  intervalEntry[T, V]{Value: value, Live: true},
+			Height: 1,
+			Size:   1,
+		}
+		t.root = insertInterval(t.root, leaf)
+	}
+
+	t.size += 1
+	pushInterval(t.root, leaf)
+}
+
+func insertInterval[T cmp.Ordered, V any](node, leaf *Node[intervalKey[T], intervalEntry[T, V]]) *Node[intervalKey[T], intervalEntry[T, V]] {
+	if node == nil {
+		return leaf
+	}
+
+	if node.Left == nil {
+		router := &Node[intervalKey[T], intervalEntry[T, V]]{Key: node.Key, Left: node, Right: leaf}
+		if compareIntervals(leaf.Key, node.Key) < 0 {
+			router.Key, router.Left, router.Right = leaf.Key, leaf, node
+		}
+		router.Value.Slot, node.Value.Slot = node.Value.Slot, nil
+		router.update()
+		return router
+	}
+
+	if compareIntervals(leaf.Key, node.Key) <= 0 {
+		node.Left = insertInterval(node.Left, leaf)
+	} else {
+		node.Right = insertInterval(node.Right, leaf)
+	}
+
+	return rebalanceFunc(node, updateInterval[T, V])
+}
+
+// Erase only empties the slot of the interval and keeps its leaf, the tree
+// is rebuilt once erased leaves outnumber live ones, so it takes O(log n)
+// amortized
+func (t *IntervalTree[T, V]) Erase(start, end T) {
+	key := intervalKey[T]{Start: start, End: end}
+	leaf := t.find(key)
+	if leaf == nil || !leaf.Value.Live {
+		return
+	}
+
+	node := t.root
+	for node.Value.Slot != leaf {
+		node = intervalChild(node, key)
+	}
+	node.Value.Slot = nil
+	pullInterval(node)
+
+	var zero V
+	leaf.Value.Value, leaf.Value.Live = zero, false
+	t.size -= 1
+	t.dead += 1
+
+	if t.dead > t.size {
+		t.rebuild()
+	}
+}
+
+func (t *IntervalTree[T, V]) rebuild() {
+	var leaves []*Node[intervalKey[T], intervalEntry[T, V]]
+	intervalLeaves(t.root, func(leaf *Node[intervalKey[T], intervalEntry[T, V]]) {
+		if leaf.Value.Live {
+			leaves = append(leaves, leaf)
+		}
+	})
+
+	t.root = buildIntervals(leaves)
+	t.dead = 0
+	for _, leaf := range leaves {
+		pushInterval(t.root, leaf)
+	}
+}
+
+func buildIntervals[T cmp.Ordered, V any](leaves []*Node[intervalKey[T], intervalEntry[T, V]]) *Node[intervalKey[T], intervalEntry[T, V]] {
+	switch len(leaves) {
+	case 0:
+		return nil
+	case 1:
+		leaves[0].Value.Slot = nil
+		return leaves[0]
+	}
+
+	middle := len(leaves) / 2
+	node := &Node[intervalKey[T], intervalEntry[T, V]]{
+		Key:   leaves[middle-1].Key,
+		Left:  buildIntervals(leaves[:middle]),
+		Right: buildIntervals(leaves[middle:]),
+	}
+	node.update()
+
+	return node
+}
+
+func intervalLeaves[T cmp.Ordered, V any](node *Node[intervalKey[T], intervalEntry[T, V]], action func(*Node[intervalKey[T], intervalEntry[T, V]])) {
+	if node == nil {
+		return
+	}
+
+	if node.Left == nil {
+		action(node)
+		return
+	}
+
+	intervalLeaves(node.Left, action)
+	intervalLeaves(node.Right, action)
+}
+
+func (t *IntervalTree[T, V]) Size() int {
+	return t.size
+}
+
+// Overlapping visits the intervals intersecting [a, b] in no particular order
+// until action returns false. A slot ending before a ends the descent, and so
+// does a slot starting after b unless the subtree still holds keys up to b,
+// which only happens along the route of b. It takes O(log n + k) for k
+// visited intervals
+func (t *IntervalTree[T, V]) Overlapping(a, b T, action func(Interval[T, V]) bool) {
+	overlapping(t.root, a, b, action)
+}
+
+// Containing visits the intervals that contain point
+func (t *IntervalTree[T, V]) Containing(point T, action func(Interval[T, V]) bool) {
+	overlapping(t.root, point, point, action)
+}
+
+func overlapping[T cmp.Ordered, V any](node *Node[intervalKey[T], intervalEntry[T, V]], a, b T, action func(Interval[T, V]) bool) bool {
+	if node == nil {
+		return true
+	}
+
+	// slots below end no later than this one
+	slot := node.Value.Slot
+	if slot == nil || slot.Key.End < a {
+		return true
+	}
+
+	if slot.Key.Start <= b && !action(Interval[T, V]{Start: slot.Key.Start, End: slot.Key.End, Value: slot.Value.Value}) {
+		return false
+	}
+
+	if node.Left == nil {
+		return true
+	}
+
+	if !overlapping(node.Left, a, b, action) {
+		return false
+	}
+
+	// the right subtree starts no earlier than this router
+	if node.Key.Start > b {
+		return true
+	}
+
+	return overlapping(node.Right, a, b, action)
+}
+
+// ForEach visits the intervals ordered by (Start, End)
+func (t *IntervalTree[T, V]) ForEach(action func(Interval[T, V])) {
+	intervalLeaves(t.root, func(leaf *Node[intervalKey[T], intervalEntry[T, V]]) {
+		if leaf.Value.Live {
+			action(Interval[T, V]{Start: leaf.Key.Start, End: leaf.Key.End, Value: leaf.Value.Value})
+		}
+	})
+}
+
+func TestIntervalTree(t *testing.T) {
+	tree := NewIntervalTree[int, string]()
+	tree.Insert(15, 20, "a")
+	tree.Insert(10, 30, "b")
+	tree.Insert(17, 19, "c")
+	tree.Insert(5, 20, "d")
+	tree.Insert(12, 15, "e")
+	tree.Insert(30, 40, "f")
+	tree.Insert(30, 40, "F")
+	tree.Insert(50, 40, "ignored")
+
+	assert.Equal(t, 6, tree.Size())
+
+	collect := func(query func(func(Interval[int, string]) bool)) []string {
+		var values []string
+		query(func(interval Interval[int, string]) bool {
+			values = append(values, interval.Value)
+			return true
+		})
+		return values
+	}
+
+	assert.ElementsMatch(t, []string{"d", "b", "e", "a"}, collect(func(action func(Interval[int, string]) bool) {
+		tree.Overlapping(14, 16, action)
+	}))
+	assert.ElementsMatch(t, []string{"b", "F"}, collect(func(action func(Interval[int, string]) bool) {
+		tree.Containing(30, action)
+	}))
+	assert.Empty(t, collect(func(action func(Interval[int, string]) bool) {
+		tree.Overlapping(41, 100, action)
+	}))
+	assert.Equal(t, []string{"d"}, collect(func(action func(Interval[int, string]) bool) {
+		tree.Overlapping(0, 5, action)
+	}))
+
+	tree.Erase(10, 30)
+	tree.Erase(10, 31)
+	assert.Equal(t, 5, tree.Size())
+	assert.Equal(t, []string{"F"}, collect(func(action func(Interval[int, string]) bool) {
+		tree.Containing(30, action)
+	}))
+
+	visited := 0
+	tree.Overlapping(0, 100, func(Interval[int, string]) bool {
+		visited++
+		return visited < 2
+	})
+	assert.Equal(t, 2, visited)
+}
+
+func TestIntervalTreeAgainstScan(t *testing.T) {
+	random := rand.New(rand.NewSource(7))
+	tree := NewIntervalTree[int, int]()
+	reference := make(map[[2]int]int)
+
+	query := func() {
+		a := random.Intn(1100)
+		b := a + random.Intn(30)
+
+		expected := 0
+		for interval := range reference {
+			if interval[0] <= b && interval[1] >= a {
+				expected++
+			}
+		}
+
+		actual := 0
+		tree.Overlapping(a, b, func(interval Interval[int, int]) bool {
+			assert.Equal(t, reference[[2]int{interval.Start, interval.End}], interval.Value)
+			assert.True(t, interval.Start <= b && interval.End >= a)
+			actual++
+			return true
+		})
+		assert.Equal(t, expected, actual)
+	}
+
+	for i := 0; i < 5000; i++ {
+		start := random.Intn(1000)
+		end := start + random.Intn(50)
+		if random.Intn(4) == 0 {
+			tree.Erase(start, end)
+			delete(reference, [2]int{start, end})
+		} else {
+			tree.Insert(start, end, i)
+			reference[[2]int{start, end}] = i
+		}
+	}
+
+	assert.Equal(t, len(reference), tree.Size())
+	checkIntervalTree(t, tree)
+	for q := 0; q < 200; q++ {
+		query()
+	}
+
+	previous := [2]int{-1, -1}
+	tree.ForEach(func(interval Interval[int, int]) {
+		key := [2]int{interval.Start, interval.End}
+		assert.True(t, previous[0] < key[0] || previous[0] == key[0] && previous[1] < key[1])
+		assert.Equal(t, reference[key], interval.Value)
+		previous = key
+	})
+
+	for interval := range reference {
+		tree.Erase(interval[0], interval[1])
+		delete(reference, interval)
+		if len(reference)%100 == 0 {
+			checkIntervalTree(t, tree)
+			query()
+		}
+	}
+
+	assert.Zero(t, tree.Size())
+	assert.Nil(t, tree.root)
+}
+
+func TestIntervalTreeLongIntervals(t *testing.T) {
+	tree := NewIntervalTree[int, int]()
+	for i := 0; i < 1024; i++ {
+		tree.Insert(10+i, 100000, i)
+	}
+	checkIntervalTree(t, tree)
+
+	// every slot reaches a but only the route of b may hold keys up to b
+	visited := 0
+	tree.Overlapping(0, 1, func(Interval[int, int]) bool {
+		visited++
+		return true
+	})
+	assert.Zero(t, visited)
+
+	tree.Overlapping(0, 12, func(interval Interval[int, int]) bool {
+		assert.LessOrEqual(t, interval.Start, 12)
+		visited++
+		return true
+	})
+	assert.Equal(t, 3, visited)
+}
+
+// checkIntervalTree checks the balance, the routing keys and that every live
+// leaf is kept by exactly one slot on its route, ordered like a heap
+func checkIntervalTree[T cmp.Ordered, V any](t *testing.T, tree IntervalTree[T, V]) {
+	kept := make(map[*Node[intervalKey[T], intervalEntry[T, V]]]int)
+	live, dead := 0, 0
+
+	var check func(node *Node[intervalKey[T], intervalEntry[T, V]]) intervalKey[T]
+	check = func(node *Node[intervalKey[T], intervalEntry[T, V]]) intervalKey[T] {
+		if slot := node.Value.Slot; slot != nil {
+			kept[slot] += 1
+			assert.True(t, slot.Value.Live)
+
+			route := node
+			for route.Left != nil {
+				route = intervalChild(route, slot.Key)
+			}
+			assert.Same(t, slot, route)
+		}
+
+		if node.Left == nil {
+			assert.Nil(t, node.Right)
+			if node.Value.Live {
+				live++
+			} else {
+				dead++
+			}
+			return node.Key
+		}
+
+		for _, child := range []*Node[intervalKey[T], intervalEntry[T, V]]{node.Left, node.Right} {
+			assert.False(t, endsAfter(child.Value.Slot, node.Value.Slot))
+		}
+		assert.LessOrEqual(t, height(node.Left)-height(node.Right), 1)
+		assert.LessOrEqual(t, height(node.Right)-height(node.Left), 1)
+
+		assert.Equal(t, node.Key, check(node.Left))
+		last := check(node.Right)
+		assert.Negative(t, compareIntervals(node.Key, last))
+
+		return last
+	}
+
+	if tree.root != nil {
+		check(tree.root)
+	}
+
+	assert.Equal(t, tree.size, live)
+	assert.Equal(t, tree.dead, dead)
+	assert.LessOrEqual(t, dead, live)
+	assert.Len(t, kept, live)
+	for _, count := range kept {
+		assert.Equal(t, 1, count)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"cmp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// OrderedMultiMap is an OrderedMap that keeps every value inserted for a
+// key, in insertion order, instead of overwriting it
+type OrderedMultiMap[K, V any] struct {
+	entries OrderedMap[K, []V]
+
+	size int
+}
+
+func NewOrderedMultiMap[K cmp.Ordered, V any]() OrderedMultiMap[K, V] {
+	return OrderedMultiMap[K, V]{
+		entries: NewOrderedMap[K, []V](),
+	}
+}
+
+func NewOrderedMultiMapFunc[K, V any](compare func(K, K) int) OrderedMultiMap[K, V] {
+	return OrderedMultiMap[K, V]{
+		entries: NewOrderedMapFunc[K, []V](compare),
+	}
+}
+
+func (m *OrderedMultiMap[K, V]) Insert(key K, value V) {
+	if node := m.entries.find(key); node != nil {
+		node.Value = append(node.Value, value)
+	} else {
+		m.entries.Insert(key, []V{value})
+	}
+
+	m.size += 1
+}
+
+// GetAll returns a copy of the values of key in insertion order
+func (m *OrderedMultiMap[K, V]) GetAll(key K) []V {
+	values, _ := m.entries.Get(key)
+	return append([]V(nil), values...)
+}
+
+// EraseOne removes the oldest value of key
+func (m *OrderedMultiMap[K, V]) EraseOne(key K) bool {
+	node := m.entries.find(key)
+	if node == nil {
+		return false
+	}
+
+	if len(node.Value) == 1 {
+		m.entries.Erase(key)
+	} else {
+		var zero V
+		node.Value[0] = zero
+		node.Value = node.Value[1:]
+	}
+	m.size -= 1
+
+	return true
+}
+
+// EraseAll removes all values of key and returns their number
+func (m *OrderedMultiMap[K, V]) EraseAll(key K) int {
+	values, found := m.entries.Get(key)
+	if !found {
+		return 0
+	}
+
+	m.entries.Erase(key)
+	m.size -= len(values)
+
+	return len(values)
+}
+
+func (m *OrderedMultiMap[K, V]) Contains(key K) bool {
+	return m.entries.Contains(key)
+}
+
+// Count returns the number of values stored for key
+func (m *OrderedMultiMap[K, V]) Count(key K) int {
+	values, _ := m.entries.Get(key)
+	return len(values)
+}
+
+// Size returns the number of values over all keys
+func (m *OrderedMultiMap[K, V]) Size() int {
+	return m.size
+}
+
+// KeysCount returns the number of distinct keys
+func (m *OrderedMultiMap[K, V]) KeysCount() int {
+	return m.entries.Size()
+}
+
+// ForEach visits the keys in order and the values of every key in
+// insertion order
+func (m *OrderedMultiMap[K, V]) ForEach(action func(K, V)) {
+	m.entries.ForEach(func(key K, values []V) {
+		for _, value := range values {
+			action(key, value)
+		}
+	})
+}
+
+// Range visits the values of the keys in [lo, hi) until action returns false
+func (m *OrderedMultiMap[K, V]) Range(lo, hi K, action func(K, V) bool) {
+	m.entries.Range(lo, hi, func(key K, values []V) bool {
+		for _, value := range values {
+			if !action(key, value) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func TestOrderedMultiMap(t *testing.T) {
+	events := NewOrderedMultiMap[int, string]()
+	assert.Zero(t, events.Size())
+	assert.False(t, events.EraseOne(1))
+	assert.Zero(t, events.EraseAll(1))
+	assert.Empty(t, events.GetAll(1))
+
+	events.Insert(20, "b1")
+	events.Insert(10, "a1")
+	events.Insert(20, "b2")
+	events.Insert(30, "c1")
+	events.Insert(20, "b3")
+
+	assert.Equal(t, 5, events.Size())
+	assert.Equal(t, 3, events.KeysCount())
+	assert.Equal(t, 3, events.Count(20))
+	assert.Zero(t, events.Count(15))
+	assert.Equal(t, []string{"b1", "b2", "b3"}, events.GetAll(20))
+
+	type entry struct {
+		key   int
+		value string
+	}
+	var visited []entry
+	events.ForEach(func(key int, value string) {
+		visited = append(visited, entry{key, value})
+	})
+	assert.Equal(t, []entry{{10, "a1"}, {20, "b1"}, {20, "b2"}, {20, "b3"}, {30, "c1"}}, visited)
+
+	visited = nil
+	events.Range(15, 40, func(key int, value string) bool {
+		visited = append(visited, entry{key, value})
+		return len(visited) < 2
+	})
+	assert.Equal(t, []entry{{20, "b1"}, {20, "b2"}}, visited)
+
+	values := events.GetAll(20)
+	values[0] = "changed"
+	assert.Equal(t, "b1", events.GetAll(20)[0])
+
+	assert.True(t, events.EraseOne(20))
+	assert.Equal(t, []string{"b2", "b3"}, events.GetAll(20))
+	assert.Equal(t, 4, events.Size())
+
+	assert.True(t, events.EraseOne(10))
+	assert.False(t, events.Contains(10))
+	assert.Equal(t, 2, events.KeysCount())
+
+	events.Insert(20, "b4")
+	assert.Equal(t, []string{"b2", "b3", "b4"}, events.GetAll(20))
+
+	assert.Equal(t, 3, events.EraseAll(20))
+	assert.False(t, events.Contains(20))
+	assert.Equal(t, 1, events.Size())
+	assert.Equal(t, 1, events.KeysCount())
+}
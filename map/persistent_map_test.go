@@ -0,0 +1,292 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// PersistentOrderedMap is an immutable AVL tree. Insert and Erase copy only
+// the nodes on the path to the changed key and return a new version that
+// shares the rest of the tree, so any version can be read concurrently
+// while newer versions are being built
+type PersistentOrderedMap[K, V any] struct {
+	bst *Node[K, V]
+
+	size    int
+	compare func(K, K) int
+}
+
+func NewPersistentOrderedMap[K cmp.Ordered, V any]() PersistentOrderedMap[K, V] {
+	return NewPersistentOrderedMapFunc[K, V](cmp.Compare[K])
+}
+
+func NewPersistentOrderedMapFunc[K, V any](compare func(K, K) int) PersistentOrderedMap[K, V] {
+	return PersistentOrderedMap[K, V]{
+		compare: compare,
+	}
+}
+
+func clone[K, V any](node *Node[K, V]) *Node[K, V] {
+	copied := *node
+	return &copied
+}
+
+func persistentRotateLeft[K, V any](node *Node[K, V]) *Node[K, V] {
+	node.Right = clone(node.Right)
+	return rotateLeft(node)
+}
+
+func persistentRotateRight[K, V any](node *Node[K, V]) *Node[K, V] {
+	node.Left = clone(node.Left)
+	return rotateRight(node)
+}
+
+// persistentRebalance works like rebalance, node must already be a copy
+func persistentRebalance[K, V any](node *Node[K, V]) *Node[K, V] {
+	node.update()
+
+	switch balance := height(node.Left) - height(node.Right); {
+	case balance > 1:
+		if height(node.Left.Left) < height(node.Left.Right) {
+			node.Left = persistentRotateLeft(clone(node.Left))
+		}
+		return persistentRotateRight(node)
+	case balance < -1:
+		if height(node.Right.Right) < height(node.Right.Left) {
+			node.Right = persistentRotateRight(clone(node.Right))
+		}
+		return persistentRotateLeft(node)
+	}
+
+	return node
+}
+
+// Insert sets the natural order on zero value maps like OrderedMap does,
+// the new version keeps it and the receiver is left unchanged
+func (m PersistentOrderedMap[K, V]) Insert(key K, value V) PersistentOrderedMap[K, V] {
+	if m.compare == nil {
+		if m.compare = defaultCompare[K](); m.compare == nil {
+			var zero K
+			panic(fmt.Sprintf("PersistentOrderedMap: key type %T is not ordered, create the map with NewPersistentOrderedMapFunc", zero))
+		}
+	}
+
+	var found bool
+	m.bst = m.insert(m.bst, key, value, &found)
+	if !found {
+		m.size += 1
+	}
+
+	return m
+}
+
+func (m PersistentOrderedMap[K, V]) insert(node *Node[K, V], key K, value V, found *bool) *Node[K, V] {
+	if node == nil {
+		return &Node[K, V]{Key: key, Value: value, Height: 1, Size: 1}
+	}
+
+	node = clone(node)
+	if c := m.compare(key, node.Key); c > 0 {
+		node.Right = m.insert(node.Right, key, value, found)
+	} else if c < 0 {
+		node.Left = m.insert(node.Left, key, value, found)
+	} else {
+		*found = true
+		node.Value = value
+		return node
+	}
+
+	return persistentRebalance(node)
+}
+
+// Erase returns m itself if key is not present
+func (m PersistentOrderedMap[K, V]) Erase(key K) PersistentOrderedMap[K, V] {
+	if !m.Contains(key) {
+		return m
+	}
+
+	m.bst = m.erase(m.bst, key)
+	m.size -= 1
+
+	return m
+}
+
+func (m PersistentOrderedMap[K, V]) erase(node *Node[K, V], key K) *Node[K, V] {
+	node = clone(node)
+	if c := m.compare(key, node.Key); c > 0 {
+		node.Right = m.erase(node.Right, key)
+	} else if c < 0 {
+		node.Left = m.erase(node.Left, key)
+	} else {
+		if node.Left == nil {
+			return node.Right
+		}
+		if node.Right == nil {
+			return node.Left
+		}
+
+		var min *Node[K, V]
+		node.Right, min = persistentEraseMin(node.Right)
+		min.Left, min.Right = node.Left, node.Right
+		node = min
+	}
+
+	return persistentRebalance(node)
+}
+
+// persistentEraseMin returns a copy of the removed node
+func persistentEraseMin[K, V any](node *Node[K, V]) (*Node[K, V], *Node[K, V]) {
+	node = clone(node)
+	if node.Left == nil {
+		return node.Right, node
+	}
+
+	var min *Node[K, V]
+	node.Left, min = persistentEraseMin(node.Left)
+
+	return persistentRebalance(node), min
+}
+
+func (m PersistentOrderedMap[K, V]) Get(key K) (V, bool) {
+	next := m.bst
+	for next != nil {
+		if c := m.compare(key, next.Key); c > 0 {
+			next = next.Right
+		} else if c < 0 {
+			next = next.Left
+		} else {
+			return next.Value, true
+		}
+	}
+
+	var zero V
+	return zero, false
+}
+
+func (m PersistentOrderedMap[K, V]) Contains(key K) bool {
+	_, found := m.Get(key)
+	return found
+}
+
+func (m PersistentOrderedMap[K, V]) Size() int {
+	return m.size
+}
+
+func (m PersistentOrderedMap[K, V]) ForEach(action func(K, V)) {
+	inorderTraverse(m.bst, action)
+}
+
+func TestPersistentOrderedMap(t *testing.T) {
+	empty := NewPersistentOrderedMap[int, string]()
+
+	v1 := empty.Insert(10, "a").Insert(5, "b").Insert(15, "c")
+	v2 := v1.Insert(10, "A").Insert(20, "d")
+	v3 := v2.Erase(10).Erase(100)
+
+	assert.Zero(t, empty.Size())
+	assert.False(t, empty.Contains(10))
+
+	keys := func(m PersistentOrderedMap[int, string]) []string {
+		var result []string
+		m.ForEach(func(_ int, value string) {
+			result = append(result, value)
+		})
+		return result
+	}
+
+	assert.Equal(t, 3, v1.Size())
+	assert.Equal(t, []string{"b", "a", "c"}, keys(v1))
+	assert.Equal(t, 4, v2.Size())
+	assert.Equal(t, []string{"b", "A", "c", "d"}, keys(v2))
+	assert.Equal(t, 3, v3.Size())
+	assert.Equal(t, []string{"b", "c", "d"}, keys(v3))
+
+	value, found := v1.Get(10)
+	assert.True(t, found)
+	assert.Equal(t, "a", value)
+	assert.False(t, v3.Contains(10))
+	assert.Same(t, v3.bst, v3.Erase(10).bst)
+}
+
+func TestPersistentOrderedMapZeroValue(t *testing.T) {
+	var empty PersistentOrderedMap[int, int]
+	data := empty.Insert(2, 2).Insert(1, 1).Insert(3, 3)
+	assert.Equal(t, 3, data.Size())
+	assert.True(t, data.Contains(1))
+	assert.Zero(t, empty.Size())
+	assert.False(t, empty.Contains(1))
+
+	type point struct {
+		x, y int
+	}
+	var unordered PersistentOrderedMap[point, int]
+	assert.PanicsWithValue(t, "PersistentOrderedMap: key type main.point is not ordered, create the map with NewPersistentOrderedMapFunc", func() {
+		unordered.Insert(point{1, 2}, 1)
+	})
+}
+
+func TestPersistentOrderedMapSharing(t *testing.T) {
+	const keysNumber = 1024
+	versions := []PersistentOrderedMap[int, int]{NewPersistentOrderedMap[int, int]()}
+	for i := 0; i < keysNumber; i++ {
+		versions = append(versions, versions[len(versions)-1].Insert(i, i))
+	}
+	for i := 0; i < keysNumber; i += 2 {
+		versions = append(versions, versions[len(versions)-1].Erase(i))
+	}
+
+	for i := 0; i <= keysNumber; i++ {
+		version := versions[i]
+		assert.Equal(t, i, version.Size())
+		assert.Equal(t, i, subtreeSize(version.bst))
+		assert.LessOrEqual(t, height(version.bst), 15)
+		assert.Equal(t, i > 0, version.Contains(i-1))
+		assert.False(t, version.Contains(i))
+	}
+
+	latest := versions[len(versions)-1]
+	assert.Equal(t, keysNumber/2, latest.Size())
+	assert.False(t, latest.Contains(0))
+	assert.True(t, latest.Contains(1))
+	assert.True(t, versions[keysNumber].Contains(0))
+}
+
+func TestPersistentOrderedMapConcurrentReaders(t *testing.T) {
+	const keysNumber = 2000
+	var published atomic.Pointer[PersistentOrderedMap[int, int]]
+	initial := NewPersistentOrderedMap[int, int]()
+	published.Store(&initial)
+
+	var wg sync.WaitGroup
+	wg.Add(4)
+	for r := 0; r < 4; r++ {
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				snapshot := published.Load()
+				expected := 0
+				snapshot.ForEach(func(key, value int) {
+					assert.Equal(t, expected, key)
+					assert.Equal(t, key, value)
+					expected++
+				})
+				assert.Equal(t, snapshot.Size(), expected)
+			}
+		}()
+	}
+
+	current := initial
+	for i := 0; i < keysNumber; i++ {
+		current = current.Insert(i, i)
+		version := current
+		published.Store(&version)
+	}
+	wg.Wait()
+
+	assert.Equal(t, keysNumber, published.Load().Size())
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ErrNoComparator = errors.New("ordered map of unordered keys must be created with NewOrderedMapFunc before decoding")
+
+type jsonEntry[K, V any] struct {
+	Key   K `json:"key"`
+	Value V `json:"value"`
+}
+
+type binaryEntries[K, V any] struct {
+	Keys   []K
+	Values []V
+}
+
+// MarshalJSON encodes the map as an array of key/value pairs in key order.
+// It has a value receiver, so maps stored by value are encoded as well
+func (m OrderedMap[K, V]) MarshalJSON() ([]byte, error) {
+	entries := make([]jsonEntry[K, V], 0, m.size)
+	m.ForEach(func(key K, value V) {
+		entries = append(entries, jsonEntry[K, V]{Key: key, Value: value})
+	})
+
+	return json.Marshal(entries)
+}
+
+// UnmarshalJSON replaces the content of the map, which keeps its comparator.
+// A zero value map, e.g. a struct field, uses cmp.Compare for ordered keys
+// and returns ErrNoComparator for other key types
+func (m *OrderedMap[K, V]) UnmarshalJSON(data []byte) error {
+	var entries []jsonEntry[K, V]
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+
+	keys := make([]K, len(entries))
+	values := make([]V, len(entries))
+	for i, entry := range entries {
+		keys[i], values[i] = entry.Key, entry.Value
+	}
+
+	return m.rebuild(keys, values)
+}
+
+// MarshalBinary encodes the keys and values with encoding/gob
+func (m OrderedMap[K, V]) MarshalBinary() ([]byte, error) {
+	keys, values := m.entries()
+
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(binaryEntries[K, V]{Keys: keys, Values: values}); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// UnmarshalBinary decodes data written by MarshalBinary, the comparator is
+// chosen like in UnmarshalJSON
+func (m *OrderedMap[K, V]) UnmarshalBinary(data []byte) error {
+	var entries binaryEntries[K, V]
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&entries); err != nil {
+		return err
+	}
+
+	if len(entries.Keys) != len(entries.Values) {
+		return ErrLengthMismatch
+	}
+
+	return m.rebuild(entries.Keys, entries.Values)
+}
+
+// rebuild builds the tree in O(n) when keys are sorted, as written by
+// Marshal*, and falls back to inserting them one by one otherwise
+func (m *OrderedMap[K, V]) rebuild(keys []K, values []V) error {
+	if m.compare == nil {
+		if m.compare = defaultCompare[K](); m.compare == nil {
+			return ErrNoComparator
+		}
+	}
+
+	rebuilt, err := FromSortedFunc(m.compare, keys, values)
+	if errors.Is(err, ErrNotSorted) {
+		rebuilt = NewOrderedMapFunc[K, V](m.compare)
+		for i := range keys {
+			rebuilt.Insert(keys[i], values[i])
+		}
+	} else if err != nil {
+		return err
+	}
+
+	m.bst, m.size = rebuilt.bst, rebuilt.size
+	m.version += 1
+
+	return nil
+}
+
+func TestOrderedMapJSON(t *testing.T) {
+	data := NewOrderedMap[string, int]()
+	data.Insert("b", 2)
+	data.Insert("a", 1)
+	data.Insert("c", 3)
+
+	encoded, err := json.Marshal(&data)
+	require.NoError(t, err)
+	assert.JSONEq(t, `[{"key":"a","value":1},{"key":"b","value":2},{"key":"c","value":3}]`, string(encoded))
+
+	decoded := NewOrderedMap[string, int]()
+	decoded.Insert("z", 26)
+	require.NoError(t, json.Unmarshal(encoded, &decoded))
+	assert.NoError(t, decoded.Validate())
+	assert.Equal(t, 3, decoded.Size())
+	assert.False(t, decoded.Contains("z"))
+	assert.Equal(t, 2, decoded.GetOrDefault("b", 0))
+
+	unsorted := NewOrderedMap[string, int]()
+	require.NoError(t, json.Unmarshal([]byte(`[{"key":"c","value":3},{"key":"a","value":1},{"key":"c","value":4}]`), &unsorted))
+	assert.NoError(t, unsorted.Validate())
+	assert.Equal(t, 2, unsorted.Size())
+	assert.Equal(t, 4, unsorted.GetOrDefault("c", 0))
+
+	empty := NewOrderedMap[string, int]()
+	encoded, err = json.Marshal(&empty)
+	require.NoError(t, err)
+	assert.Equal(t, "[]", string(encoded))
+
+	var zero OrderedMap[string, int]
+	require.NoError(t, json.Unmarshal([]byte(`[{"key":"b","value":2},{"key":"a","value":1}]`), &zero))
+	assert.NoError(t, zero.Validate())
+	assert.Equal(t, 1, zero.GetOrDefault("a", 0))
+
+	var unordered OrderedMap[[2]int, int]
+	assert.ErrorIs(t, json.Unmarshal([]byte(`[]`), &unordered), ErrNoComparator)
+	assert.Error(t, json.Unmarshal([]byte(`{"a":1}`), &decoded))
+}
+
+func TestOrderedMapBinary(t *testing.T) {
+	type record struct {
+		Name  string
+		Score float64
+	}
+
+	data := NewOrderedMap[int, record]()
+	for i := 0; i < 100; i++ {
+		data.Insert(i*7%100, record{Name: "r", Score: float64(i)})
+	}
+
+	encoded, err := data.MarshalBinary()
+	require.NoError(t, err)
+
+	decoded := NewOrderedMap[int, record]()
+	require.NoError(t, decoded.UnmarshalBinary(encoded))
+	assert.NoError(t, decoded.Validate())
+	assert.Equal(t, data.Size(), decoded.Size())
+
+	data.ForEach(func(key int, value record) {
+		actual, found := decoded.Get(key)
+		assert.True(t, found)
+		assert.Equal(t, value, actual)
+	})
+
+	assert.Error(t, decoded.UnmarshalBinary([]byte("garbage")))
+}
+
+func TestOrderedMapByValue(t *testing.T) {
+	type document struct {
+		Title  string
+		Scores OrderedMap[string, int]
+	}
+
+	data := NewOrderedMap[string, int]()
+	data.Insert("b", 2)
+	data.Insert("a", 1)
+
+	encoded, err := json.Marshal(data)
+	require.NoError(t, err)
+	assert.JSONEq(t, `[{"key":"a","value":1},{"key":"b","value":2}]`, string(encoded))
+
+	encoded, err = json.Marshal(document{Title: "t", Scores: data})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"Title":"t","Scores":[{"key":"a","value":1},{"key":"b","value":2}]}`, string(encoded))
+
+	var decoded document
+	require.NoError(t, json.Unmarshal(encoded, &decoded))
+	assert.Equal(t, "t", decoded.Title)
+	assert.NoError(t, decoded.Scores.Validate())
+	assert.Equal(t, 2, decoded.Scores.GetOrDefault("b", 0))
+
+	var buffer bytes.Buffer
+	require.NoError(t, gob.NewEncoder(&buffer).Encode(document{Title: "t", Scores: data}))
+
+	var gobDecoded document
+	require.NoError(t, gob.NewDecoder(&buffer).Decode(&gobDecoded))
+	assert.Equal(t, "t", gobDecoded.Title)
+	assert.Equal(t, 2, gobDecoded.Scores.Size())
+	assert.Equal(t, 1, gobDecoded.Scores.GetOrDefault("a", 0))
+}
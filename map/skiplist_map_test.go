@@ -0,0 +1,557 @@
+package main
+
+import (
+	"cmp"
+	"math/rand/v2"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const skipListMaxLevel = 32
+
+type skipListNode[K, V any] struct {
+	key   K
+	value atomic.Pointer[V]
+	next  []atomic.Pointer[skipListNode[K, V]]
+
+	mutex       sync.Mutex
+	marked      atomic.Bool
+	fullyLinked atomic.Bool
+}
+
+func (n *skipListNode[K, V]) topLevel() int {
+	return len(n.next) - 1
+}
+
+// ConcurrentOrderedMap is a lazy skip list: writers lock only the
+// predecessors of the changed node, while readers never lock. A node is
+// logically removed when it is marked and logically present once it is
+// fully linked on all of its levels
+type ConcurrentOrderedMap[K, V any] struct {
+	head    *skipListNode[K, V]
+	size    atomic.Int64
+	compare func(K, K) int
+}
+
+func NewConcurrentOrderedMap[K cmp.Ordered, V any]() *ConcurrentOrderedMap[K, V] {
+	return NewConcurrentOrderedMapFunc[K, V](cmp.Compare[K])
+}
+
+func NewConcurrentOrderedMapFunc[K, V any](compare func(K, K) int) *ConcurrentOrderedMap[K, V] {
+	return &ConcurrentOrderedMap[K, V]{
+		head: &skipListNode[K, V]{
+			next: make([]atomic.Pointer[skipListNode[K, V]], skipListMaxLevel),
+		},
+		compare: compare,
+	}
+}
+
+func randomLevel() int {
+	level := 0
+	for level < skipListMaxLevel-1 && rand.Uint32()&1 == 0 {
+		level++
+	}
+
+	return level
+}
+
+// find fills the predecessors and successors of key on every level and
+// returns the highest level where key was found, or -1
+func (m *ConcurrentOrderedMap[K, V]) find(key K, preds, succs []*skipListNode[K, V]) int {
+	found := -1
+	pred := m.head
+	for level := skipListMaxLevel - 1; level >= 0; level-- {
+		curr := pred.next[level].Load()
+		for curr != nil && m.compare(key, curr.key) > 0 {
+			pred = curr
+			curr = pred.next[level].Load()
+		}
+
+		if found == -1 && curr != nil && m.compare(key, curr.key) == 0 {
+			found = level
+		}
+		preds[level] = pred
+		succs[level] = curr
+	}
+
+	return found
+}
+
+// lockPredecessors locks every distinct predecessor up to topLevel and
+// checks that none of them changed since find. The returned function
+// unlocks what has been locked
+func lockPredecessors[K, V any](preds, succs []*skipListNode[K, V], topLevel int, valid func(pred, succ *skipListNode[K, V], level int) bool) (func(), bool) {
+	var locked []*skipListNode[K, V]
+	unlock := func() {
+		for _, node := range locked {
+			node.mutex.Unlock()
+		}
+	}
+
+	for level := 0; level <= topLevel; level++ {
+		pred := preds[level]
+		if len(locked) == 0 || locked[len(locked)-1] != pred {
+			pred.mutex.Lock()
+			locked = append(locked, pred)
+		}
+
+		if !valid(pred, succs[level], level) {
+			return unlock, false
+		}
+	}
+
+	return unlock, true
+}
+
+func (m *ConcurrentOrderedMap[K, V]) Insert(key K, value V) {
+	var preds, succs [skipListMaxLevel]*skipListNode[K, V]
+	topLevel := randomLevel()
+
+	for {
+		if found := m.find(key, preds[:], succs[:]); found != -1 {
+			node := succs[found]
+			if node.marked.Load() {
+				continue
+			}
+			for !node.fullyLinked.Load() {
+				runtime.Gosched()
+			}
+			node.value.Store(&value)
+			return
+		}
+
+		unlock, valid := lockPredecessors(preds[:], succs[:], topLevel, func(pred, succ *skipListNode[K, V], level int) bool {
+			return !pred.marked.Load() && (succ == nil || !succ.marked.Load()) && pred.next[level].Load() == succ
+		})
+		if !valid {
+			unlock()
+			continue
+		}
+
+		node := &skipListNode[K, V]{
+			key:  key,
+			next: make([]atomic.Pointer[skipListNode[K, V]], topLevel+1),
+		}
+		node.value.Store(&value)
+		for level := 0; level <= topLevel; level++ {
+			node.next[level].Store(succs[level])
+		}
+		for level := 0; level <= topLevel; level++ {
+			preds[level].next[level].Store(node)
+		}
+		node.fullyLinked.Store(true)
+		m.size.Add(1)
+
+		unlock()
+		return
+	}
+}
+
+// Erase reports whether this call removed key. Unlike OrderedMap.Erase it
+// returns a result, because with concurrent writers a following Contains
+// can't tell which of several racing Erase calls removed the key. At most
+// one of them returns true
+func (m *ConcurrentOrderedMap[K, V]) Erase(key K) bool {
+	var (
+		preds, succs [skipListMaxLevel]*skipListNode[K, V]
+		victim       *skipListNode[K, V]
+	)
+
+	for {
+		found := m.find(key, preds[:], succs[:])
+		if victim == nil {
+			if found == -1 {
+				return false
+			}
+
+			node := succs[found]
+			if !node.fullyLinked.Load() || node.topLevel() != found || node.marked.Load() {
+				return false
+			}
+
+			node.mutex.Lock()
+			if node.marked.Load() {
+				node.mutex.Unlock()
+				return false
+			}
+			node.marked.Store(true)
+			victim = node
+		}
+
+		unlock, valid := lockPredecessors(preds[:], succs[:], victim.topLevel(), func(pred, _ *skipListNode[K, V], level int) bool {
+			return !pred.marked.Load() && pred.next[level].Load() == victim
+		})
+		if !valid {
+			unlock()
+			continue
+		}
+
+		for level := victim.topLevel(); level >= 0; level-- {
+			preds[level].next[level].Store(victim.next[level].Load())
+		}
+		m.size.Add(-1)
+
+		victim.mutex.Unlock()
+		unlock()
+		return true
+	}
+}
+
+func (m *ConcurrentOrderedMap[K, V]) Get(key K) (V, bool) {
+	var preds, succs [skipListMaxLevel]*skipListNode[K, V]
+	if found := m.find(key, preds[:], succs[:]); found != -1 {
+		node := succs[found]
+		if node.fullyLinked.Load() && !node.marked.Load() {
+			return *node.value.Load(), true
+		}
+	}
+
+	var zero V
+	return zero, false
+}
+
+func (m *ConcurrentOrderedMap[K, V]) Contains(key K) bool {
+	_, found := m.Get(key)
+	return found
+}
+
+func (m *ConcurrentOrderedMap[K, V]) Size() int {
+	return int(m.size.Load())
+}
+
+// ForEach is weakly consistent: it sees every key present for the whole
+// traversal and may or may not see keys changed concurrently
+func (m *ConcurrentOrderedMap[K, V]) ForEach(action func(K, V)) {
+	for node := m.head.next[0].Load(); node != nil; node = node.next[0].Load() {
+		if node.fullyLinked.Load() && !node.marked.Load() {
+			action(node.key, *node.value.Load())
+		}
+	}
+}
+
+// Range visits the keys in [lo, hi) until action returns false, with the
+// same consistency as ForEach
+func (m *ConcurrentOrderedMap[K, V]) Range(lo, hi K, action func(K, V) bool) {
+	pred := m.head
+	for level := skipListMaxLevel - 1; level >= 0; level-- {
+		for curr := pred.next[level].Load(); curr != nil && m.compare(curr.key, lo) < 0; curr = pred.next[level].Load() {
+			pred = curr
+		}
+	}
+
+	for node := pred.next[0].Load(); node != nil && m.compare(node.key, hi) < 0; node = node.next[0].Load() {
+		if node.fullyLinked.Load() && !node.marked.Load() && !action(node.key, *node.value.Load()) {
+			return
+		}
+	}
+}
+
+func TestConcurrentOrderedMap(t *testing.T) {
+	data := NewConcurrentOrderedMap[int, string]()
+	assert.Zero(t, data.Size())
+	assert.False(t, data.Erase(1))
+
+	data.Insert(10, "a")
+	data.Insert(5, "b")
+	data.Insert(15, "c")
+	data.Insert(10, "A")
+
+	assert.Equal(t, 3, data.Size())
+	value, found := data.Get(10)
+	assert.True(t, found)
+	assert.Equal(t, "A", value)
+	assert.False(t, data.Contains(7))
+
+	var keys []int
+	data.ForEach(func(key int, _ string) {
+		keys = append(keys, key)
+	})
+	assert.Equal(t, []int{5, 10, 15}, keys)
+
+	keys = nil
+	data.Range(6, 15, func(key int, _ string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	assert.Equal(t, []int{10}, keys)
+
+	assert.True(t, data.Erase(10))
+	assert.False(t, data.Erase(10))
+	assert.False(t, data.Contains(10))
+	assert.Equal(t, 2, data.Size())
+}
+
+func TestConcurrentOrderedMapParallelWriters(t *testing.T) {
+	const writersNumber = 8
+	const keysNumber = 2000
+	data := NewConcurrentOrderedMap[int, int]()
+
+	var wg sync.WaitGroup
+	wg.Add(writersNumber)
+	for w := 0; w < writersNumber; w++ {
+		go func() {
+			defer wg.Done()
+			for _, key := range rand.Perm(keysNumber) {
+				data.Insert(key, w)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, keysNumber, data.Size())
+
+	// every key is erased exactly once no matter how many goroutines race
+	var erased atomic.Int64
+	wg.Add(writersNumber)
+	for w := 0; w < writersNumber; w++ {
+		go func() {
+			defer wg.Done()
+			for _, key := range rand.Perm(keysNumber) {
+				if data.Erase(key) {
+					erased.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(keysNumber), erased.Load())
+	assert.Zero(t, data.Size())
+	data.ForEach(func(key, _ int) {
+		assert.Fail(t, "unexpected key", key)
+	})
+}
+
+func TestConcurrentOrderedMapReadersAndWriters(t *testing.T) {
+	const keysNumber = 1000
+	data := NewConcurrentOrderedMap[int, int]()
+
+	// even keys are never touched by writers, so every reader must see them
+	for key := 0; key < keysNumber; key += 2 {
+		data.Insert(key, key)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(4)
+	for w := 0; w < 4; w++ {
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 5*keysNumber; i++ {
+				key := rand.IntN(keysNumber/2)*2 + 1
+				if i%2 == 0 {
+					data.Insert(key, key)
+				} else {
+					data.Erase(key)
+				}
+			}
+		}()
+	}
+
+	var readers sync.WaitGroup
+	readers.Add(4)
+	for r := 0; r < 4; r++ {
+		go func() {
+			defer readers.Done()
+			for i := 0; i < 20; i++ {
+				previous, even := -1, 0
+				data.ForEach(func(key, value int) {
+					assert.Less(t, previous, key)
+					assert.Equal(t, key, value)
+					previous = key
+					if key%2 == 0 {
+						even++
+					}
+				})
+				assert.Equal(t, keysNumber/2, even)
+
+				for key := 0; key < keysNumber; key += 50 {
+					assert.True(t, data.Contains(key))
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	readers.Wait()
+
+	count := 0
+	data.ForEach(func(int, int) {
+		count++
+	})
+	assert.Equal(t, count, data.Size())
+}
+
+type historyOperation int
+
+const (
+	historyInsert historyOperation = iota
+	historyErase
+	historyGet
+)
+
+// historyEvent is a completed call with its invocation and response times
+// taken from a shared logical clock
+type historyEvent struct {
+	operation historyOperation
+	value     int
+
+	found  bool
+	result int
+
+	start int64
+	end   int64
+}
+
+// linearizable searches for an order of events that respects real time,
+// i.e. an event that ended before another started goes first, and that
+// gives the observed results when replayed on a single-key sequential map
+func linearizable(events []historyEvent) bool {
+	type state struct {
+		done    uint64
+		present bool
+		value   int
+	}
+	visited := make(map[state]bool)
+
+	var search func(current state) bool
+	search = func(current state) bool {
+		if current.done == 1<<len(events)-1 {
+			return true
+		}
+		if visited[current] {
+			return false
+		}
+		visited[current] = true
+
+		for i, event := range events {
+			if current.done&(1<<i) != 0 || !minimalEvent(events, current.done, i) {
+				continue
+			}
+
+			next := current
+			next.done |= 1 << i
+			switch event.operation {
+			case historyInsert:
+				next.present, next.value = true, event.value
+			case historyErase:
+				if event.found != current.present {
+					continue
+				}
+				next.present = false
+			case historyGet:
+				if event.found != current.present || event.found && event.result != current.value {
+					continue
+				}
+			}
+
+			if search(next) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return search(state{})
+}
+
+func minimalEvent(events []historyEvent, done uint64, i int) bool {
+	for j, other := range events {
+		if done&(1<<j) == 0 && other.end < events[i].start {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestConcurrentOrderedMapLinearizability(t *testing.T) {
+	const runsNumber = 300
+	const goroutinesNumber = 3
+	const operationsNumber = 4
+	const keysNumber = 2
+
+	for run := 0; run < runsNumber; run++ {
+		data := NewConcurrentOrderedMap[int, int]()
+
+		var (
+			clock   atomic.Int64
+			mutex   sync.Mutex
+			history [keysNumber][]historyEvent
+			wg      sync.WaitGroup
+		)
+
+		start := make(chan struct{})
+		wg.Add(goroutinesNumber)
+		for g := 0; g < goroutinesNumber; g++ {
+			go func() {
+				defer wg.Done()
+				<-start
+				for i := 0; i < operationsNumber; i++ {
+					key := rand.IntN(keysNumber)
+					event := historyEvent{
+						operation: historyOperation(rand.IntN(3)),
+						value:     g*operationsNumber + i + 1,
+					}
+
+					event.start = clock.Add(1)
+					switch event.operation {
+					case historyInsert:
+						data.Insert(key, event.value)
+					case historyErase:
+						event.found = data.Erase(key)
+					case historyGet:
+						event.result, event.found = data.Get(key)
+					}
+					event.end = clock.Add(1)
+
+					mutex.Lock()
+					history[key] = append(history[key], event)
+					mutex.Unlock()
+				}
+			}()
+		}
+		close(start)
+		wg.Wait()
+
+		// linearizability is local, so every key can be checked on its own
+		for key, events := range history {
+			if !linearizable(events) {
+				t.Fatalf("run %d: history of key %d is not linearizable: %+v", run, key, events)
+			}
+		}
+	}
+}
+
+func TestLinearizabilityChecker(t *testing.T) {
+	sequential := []historyEvent{
+		{operation: historyInsert, value: 1, start: 1, end: 2},
+		{operation: historyGet, found: true, result: 1, start: 3, end: 4},
+		{operation: historyErase, found: true, start: 5, end: 6},
+		{operation: historyGet, start: 7, end: 8},
+	}
+	assert.True(t, linearizable(sequential))
+
+	staleRead := []historyEvent{
+		{operation: historyInsert, value: 1, start: 1, end: 2},
+		{operation: historyGet, start: 3, end: 4},
+	}
+	assert.False(t, linearizable(staleRead))
+
+	overlapping := []historyEvent{
+		{operation: historyInsert, value: 1, start: 1, end: 4},
+		{operation: historyGet, start: 2, end: 3},
+	}
+	assert.True(t, linearizable(overlapping))
+
+	doubleErase := []historyEvent{
+		{operation: historyInsert, value: 1, start: 1, end: 2},
+		{operation: historyErase, found: true, start: 3, end: 6},
+		{operation: historyErase, found: true, start: 4, end: 5},
+	}
+	assert.False(t, linearizable(doubleErase))
+}
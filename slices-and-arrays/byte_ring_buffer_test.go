@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	ErrRingBufferFull   = errors.New("ring buffer is full")
+	ErrRingBufferClosed = errors.New("ring buffer is closed")
+	ErrPeekTooLarge     = errors.New("peek size exceeds ring buffer capacity")
+	ErrNegativeCount    = errors.New("negative count")
+)
+
+// ByteRingBuffer is a byte ring buffer with the CircularQueue layout. In
+// blocking mode Write waits for free space and Read waits for data until
+// the buffer is closed, which makes it usable as an in-memory pipe
+type ByteRingBuffer struct {
+	mutex    sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+
+	values []byte
+	front  int
+	count  int
+
+	blocking bool
+	closed   bool
+}
+
+func NewByteRingBuffer(size int) *ByteRingBuffer {
+	b := &ByteRingBuffer{
+		values: make([]byte, size),
+	}
+	b.notEmpty = sync.NewCond(&b.mutex)
+	b.notFull = sync.NewCond(&b.mutex)
+
+	return b
+}
+
+func NewBlockingByteRingBuffer(size int) *ByteRingBuffer {
+	b := NewByteRingBuffer(size)
+	b.blocking = true
+
+	return b
+}
+
+// Write returns ErrRingBufferFull on a short write in non-blocking mode,
+// and in blocking mode too when the buffer has size 0 and can never fit p
+func (b *ByteRingBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	written := 0
+	for written < len(p) {
+		if b.closed {
+			return written, ErrRingBufferClosed
+		}
+
+		if b.count == len(b.values) {
+			if !b.blocking || len(b.values) == 0 {
+				return written, ErrRingBufferFull
+			}
+			b.notFull.Wait()
+			continue
+		}
+
+		start := (b.front + b.count) % len(b.values)
+		end := len(b.values)
+		if start < b.front {
+			end = b.front
+		}
+		n := copy(b.values[start:end], p[written:])
+		if end == len(b.values) && start >= b.front {
+			n += copy(b.values[:b.front], p[written+n:])
+		}
+
+		b.count += n
+		written += n
+		b.notEmpty.Broadcast()
+	}
+
+	return written, nil
+}
+
+// Read returns io.EOF when the buffer is empty, in blocking mode only after
+// it has been closed or when it has size 0 and can never receive data
+func (b *ByteRingBuffer) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for b.count == 0 {
+		if b.closed || !b.blocking || len(b.values) == 0 {
+			return 0, io.EOF
+		}
+		b.notEmpty.Wait()
+	}
+
+	n := b.peek(p)
+	b.front = (b.front + n) % len(b.values)
+	b.count -= n
+	b.notFull.Broadcast()
+
+	return n, nil
+}
+
+func (b *ByteRingBuffer) ReadByte() (byte, error) {
+	var p [1]byte
+	if _, err := b.Read(p[:]); err != nil {
+		return 0, err
+	}
+
+	return p[0], nil
+}
+
+// Peek returns a copy of the next n bytes without consuming them. If fewer
+// bytes are available, it returns them together with io.EOF
+func (b *ByteRingBuffer) Peek(n int) ([]byte, error) {
+	if n < 0 {
+		return nil, ErrNegativeCount
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if n > len(b.values) {
+		return nil, ErrPeekTooLarge
+	}
+
+	for b.blocking && b.count < n && !b.closed {
+		b.notEmpty.Wait()
+	}
+
+	p := make([]byte, min(n, b.count))
+	b.peek(p)
+	if len(p) < n {
+		return p, io.EOF
+	}
+
+	return p, nil
+}
+
+// Close wakes up all blocked readers and writers. Data that is already
+// buffered can still be read
+func (b *ByteRingBuffer) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.closed = true
+	b.notEmpty.Broadcast()
+	b.notFull.Broadcast()
+
+	return nil
+}
+
+func (b *ByteRingBuffer) Len() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.count
+}
+
+func (b *ByteRingBuffer) Cap() int {
+	return len(b.values)
+}
+
+func (b *ByteRingBuffer) peek(p []byte) int {
+	end := min(b.front+b.count, len(b.values))
+	n := copy(p, b.values[b.front:end])
+	if n < len(p) && n < b.count {
+		n += copy(p[n:], b.values[:b.count-n])
+	}
+
+	return n
+}
+
+func TestByteRingBuffer(t *testing.T) {
+	buffer := NewByteRingBuffer(8)
+	assert.Equal(t, 8, buffer.Cap())
+
+	n, err := buffer.Read(make([]byte, 4))
+	assert.Zero(t, n)
+	assert.ErrorIs(t, err, io.EOF)
+
+	n, err = buffer.Write([]byte("hello"))
+	assert.Equal(t, 5, n)
+	assert.NoError(t, err)
+
+	peeked, err := buffer.Peek(3)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hel"), peeked)
+
+	peeked, err = buffer.Peek(6)
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, []byte("hello"), peeked)
+
+	_, err = buffer.Peek(9)
+	assert.ErrorIs(t, err, ErrPeekTooLarge)
+	_, err = buffer.Peek(-1)
+	assert.ErrorIs(t, err, ErrNegativeCount)
+
+	c, err := buffer.ReadByte()
+	assert.NoError(t, err)
+	assert.Equal(t, byte('h'), c)
+
+	n, err = buffer.Write([]byte(", world"))
+	assert.Equal(t, 4, n)
+	assert.ErrorIs(t, err, ErrRingBufferFull)
+	assert.Equal(t, 8, buffer.Len())
+
+	peeked, err = buffer.Peek(8)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("ello, wo"), peeked)
+
+	p := make([]byte, 16)
+	n, err = buffer.Read(p[:6])
+	assert.NoError(t, err)
+	assert.Equal(t, "ello, ", string(p[:n]))
+
+	n, err = buffer.Write([]byte("rld!!!!"))
+	assert.Equal(t, 6, n)
+	assert.ErrorIs(t, err, ErrRingBufferFull)
+
+	n, err = buffer.Read(p)
+	assert.NoError(t, err)
+	assert.Equal(t, "world!!!", string(p[:n]))
+	assert.Zero(t, buffer.Len())
+
+	assert.NoError(t, buffer.Close())
+	_, err = buffer.Write([]byte("x"))
+	assert.ErrorIs(t, err, ErrRingBufferClosed)
+}
+
+func TestBlockingByteRingBuffer(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1024)
+	buffer := NewBlockingByteRingBuffer(100)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		n, err := buffer.Write(data)
+		assert.Equal(t, len(data), n)
+		assert.NoError(t, err)
+		assert.NoError(t, buffer.Close())
+	}()
+
+	received, err := io.ReadAll(buffer)
+	wg.Wait()
+
+	assert.NoError(t, err)
+	assert.Equal(t, data, received)
+
+	_, err = buffer.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestBlockingByteRingBufferZeroSize(t *testing.T) {
+	buffer := NewBlockingByteRingBuffer(0)
+
+	n, err := buffer.Write([]byte("abc"))
+	assert.Zero(t, n)
+	assert.ErrorIs(t, err, ErrRingBufferFull)
+
+	n, err = buffer.Write(nil)
+	assert.Zero(t, n)
+	assert.NoError(t, err)
+
+	n, err = buffer.Read(make([]byte, 3))
+	assert.Zero(t, n)
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestBlockingByteRingBufferPeek(t *testing.T) {
+	buffer := NewBlockingByteRingBuffer(4)
+
+	go func() {
+		_, _ = buffer.Write([]byte("ab"))
+		_, _ = buffer.Write([]byte("cd"))
+	}()
+
+	peeked, err := buffer.Peek(4)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("abcd"), peeked)
+
+	_ = buffer.Close()
+	_, err = buffer.Write([]byte("e"))
+	assert.ErrorIs(t, err, ErrRingBufferClosed)
+}
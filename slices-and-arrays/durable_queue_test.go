@@ -0,0 +1,501 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type SyncPolicy int
+
+const (
+	// SyncAlways fsyncs every slot and header write, so a Push or Pop that
+	// returned true survives a power loss
+	SyncAlways SyncPolicy = iota
+	// SyncNever leaves flushing to the OS and explicit Sync calls
+	SyncNever
+)
+
+// SyncEvery returns a policy that fsyncs the header once every n Push and
+// Pop calls, so a power loss loses at most the last n-1 of them. Values
+// above SyncNever are batch sizes, n <= 1 is SyncAlways
+func SyncEvery(n int) SyncPolicy {
+	if n <= 1 {
+		return SyncAlways
+	}
+
+	return SyncPolicy(n)
+}
+
+var (
+	ErrCorruptedQueue = errors.New("queue file is corrupted")
+	ErrSizeMismatch   = errors.New("queue file has a different size")
+)
+
+// The file starts with two header copies that are written alternately, so
+// a torn header write always leaves the previous one intact. Slots store
+// the absolute position of the value, which detects stale slots as well as
+// torn ones
+//
+//	header: magic u32 | size u32 | sequence u64 | head u64 | count u32 | crc u32
+//	slot:   position u64 | value i64 | crc u32
+const (
+	durableQueueMagic = 0x31514344
+
+	headerSize  = 32
+	headersSize = 2 * headerSize
+	slotSize    = 20
+)
+
+type DurableCircularQueue[T Integer] struct {
+	file   *os.File
+	policy SyncPolicy
+	err    error
+
+	values []T
+	size   int
+	count  int
+
+	head     uint64
+	sequence uint64
+	// unsynced counts the header writes since the last fsync in batch mode
+	unsynced int
+}
+
+// OpenDurableCircularQueue creates the queue file or recovers the queue
+// from it. Values after the first slot that fails validation are dropped.
+// Without SyncAlways a Pop may be lost, then its value is delivered again
+func OpenDurableCircularQueue[T Integer](path string, size int, policy SyncPolicy) (*DurableCircularQueue[T], error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &DurableCircularQueue[T]{
+		file:   file,
+		policy: policy,
+		values: make([]T, size),
+		size:   size,
+	}
+
+	info, err := file.Stat()
+	if err == nil {
+		if info.Size() == 0 {
+			err = q.create()
+		} else {
+			err = q.recover()
+		}
+	}
+
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return q, nil
+}
+
+func (q *DurableCircularQueue[T]) create() error {
+	if err := q.file.Truncate(int64(headersSize + q.size*slotSize)); err != nil {
+		return err
+	}
+
+	// both copies must be valid before the first write
+	if err := q.writeHeader(); err != nil {
+		return err
+	}
+	if err := q.writeHeader(); err != nil {
+		return err
+	}
+
+	// batches start with the new file on disk
+	if q.policy > SyncNever {
+		return q.sync()
+	}
+
+	return nil
+}
+
+func (q *DurableCircularQueue[T]) recover() error {
+	var headers [headersSize]byte
+	if _, err := q.file.ReadAt(headers[:], 0); err != nil {
+		return fmt.Errorf("%w: %w", ErrCorruptedQueue, err)
+	}
+
+	found := false
+	for i := 0; i < 2; i++ {
+		header := headers[i*headerSize : (i+1)*headerSize]
+		if binary.LittleEndian.Uint32(header[0:]) != durableQueueMagic ||
+			binary.LittleEndian.Uint32(header[28:]) != crc32.ChecksumIEEE(header[:28]) {
+			continue
+		}
+
+		sequence := binary.LittleEndian.Uint64(header[8:])
+		if found && sequence < q.sequence {
+			continue
+		}
+
+		if size := int(binary.LittleEndian.Uint32(header[4:])); size != q.size {
+			return fmt.Errorf("%w: %d, expected %d", ErrSizeMismatch, size, q.size)
+		}
+
+		found = true
+		q.sequence = sequence
+		q.head = binary.LittleEndian.Uint64(header[16:])
+		q.count = int(binary.LittleEndian.Uint32(header[24:]))
+	}
+
+	if !found || q.count > q.size {
+		return ErrCorruptedQueue
+	}
+
+	var slot [slotSize]byte
+	head, end := q.head, q.head+uint64(q.count)
+	for position := head; position < end; position++ {
+		if _, err := q.file.ReadAt(slot[:], q.slotOffset(position)); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		stored := binary.LittleEndian.Uint64(slot[0:])
+		valid := binary.LittleEndian.Uint32(slot[16:]) == crc32.ChecksumIEEE(slot[:16])
+		switch {
+		case valid && stored == position:
+			q.values[position%uint64(q.size)] = T(int64(binary.LittleEndian.Uint64(slot[8:])))
+		case valid && stored == position+uint64(q.size):
+			// a Push reused the slot, so the Pop of this position happened
+			// but its header never reached the disk, neither did the ones
+			// of the Pops before it
+			q.head = position + 1
+		default:
+			end = position
+		}
+	}
+
+	if q.head == head && end == head+uint64(q.count) {
+		return nil
+	}
+
+	q.count = int(end - q.head)
+	return q.writeHeader()
+}
+
+func (q *DurableCircularQueue[T]) Push(value T) bool {
+	if q.err != nil || q.Full() {
+		return false
+	}
+
+	position := q.head + uint64(q.count)
+
+	var slot [slotSize]byte
+	binary.LittleEndian.PutUint64(slot[0:], position)
+	binary.LittleEndian.PutUint64(slot[8:], uint64(int64(value)))
+	binary.LittleEndian.PutUint32(slot[16:], crc32.ChecksumIEEE(slot[:16]))
+
+	if _, err := q.file.WriteAt(slot[:], q.slotOffset(position)); err != nil {
+		q.err = err
+		return false
+	}
+	if err := q.syncIfNeeded(); err != nil {
+		return false
+	}
+
+	q.count += 1
+	if err := q.writeHeader(); err != nil {
+		q.count -= 1
+		return false
+	}
+
+	q.values[position%uint64(q.size)] = value
+	return true
+}
+
+func (q *DurableCircularQueue[T]) Pop() bool {
+	if q.err != nil || q.Empty() {
+		return false
+	}
+
+	q.head += 1
+	q.count -= 1
+	if err := q.writeHeader(); err != nil {
+		q.head -= 1
+		q.count += 1
+		return false
+	}
+
+	return true
+}
+
+func (q *DurableCircularQueue[T]) Front() T {
+	if q.Empty() {
+		return -1
+	}
+
+	return q.values[q.head%uint64(q.size)]
+}
+
+func (q *DurableCircularQueue[T]) Back() T {
+	if q.Empty() {
+		return -1
+	}
+
+	return q.values[(q.head+uint64(q.count)-1)%uint64(q.size)]
+}
+
+func (q *DurableCircularQueue[T]) Empty() bool {
+	return q.count == 0
+}
+
+func (q *DurableCircularQueue[T]) Full() bool {
+	return q.count == q.size
+}
+
+// Err returns the I/O error that made Push or Pop fail. After an error the
+// queue rejects all writes, reopen it to recover
+func (q *DurableCircularQueue[T]) Err() error {
+	return q.err
+}
+
+func (q *DurableCircularQueue[T]) Sync() error {
+	return q.sync()
+}
+
+func (q *DurableCircularQueue[T]) Close() error {
+	return q.file.Close()
+}
+
+func (q *DurableCircularQueue[T]) writeHeader() error {
+	q.sequence += 1
+
+	var header [headerSize]byte
+	binary.LittleEndian.PutUint32(header[0:], durableQueueMagic)
+	binary.LittleEndian.PutUint32(header[4:], uint32(q.size))
+	binary.LittleEndian.PutUint64(header[8:], q.sequence)
+	binary.LittleEndian.PutUint64(header[16:], q.head)
+	binary.LittleEndian.PutUint32(header[24:], uint32(q.count))
+	binary.LittleEndian.PutUint32(header[28:], crc32.ChecksumIEEE(header[:28]))
+
+	if _, err := q.file.WriteAt(header[:], int64(q.sequence%2)*headerSize); err != nil {
+		q.err = err
+		return err
+	}
+
+	if q.policy > SyncNever {
+		if q.unsynced += 1; q.unsynced < int(q.policy) {
+			return nil
+		}
+		return q.sync()
+	}
+
+	return q.syncIfNeeded()
+}
+
+// syncIfNeeded fsyncs with SyncAlways only, batches skip slot writes since
+// a slot is checked on recovery and only counts once its header is written
+func (q *DurableCircularQueue[T]) syncIfNeeded() error {
+	if q.policy != SyncAlways {
+		return nil
+	}
+
+	return q.sync()
+}
+
+func (q *DurableCircularQueue[T]) sync() error {
+	if err := q.file.Sync(); err != nil {
+		q.err = err
+		return err
+	}
+
+	q.unsynced = 0
+	return nil
+}
+
+func (q *DurableCircularQueue[T]) slotOffset(position uint64) int64 {
+	return headersSize + int64(position%uint64(q.size))*slotSize
+}
+
+func TestDurableCircularQueue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue")
+	queue, err := OpenDurableCircularQueue[int64](path, 3, SyncAlways)
+	require.NoError(t, err)
+
+	assert.True(t, queue.Empty())
+	assert.Equal(t, int64(-1), queue.Front())
+	assert.Equal(t, int64(-1), queue.Back())
+	assert.False(t, queue.Pop())
+
+	assert.True(t, queue.Push(1))
+	assert.True(t, queue.Push(-2))
+	assert.True(t, queue.Push(3))
+	assert.False(t, queue.Push(4))
+	assert.True(t, queue.Full())
+
+	assert.True(t, queue.Pop())
+	assert.True(t, queue.Push(4))
+	assert.NoError(t, queue.Err())
+	require.NoError(t, queue.Close())
+
+	queue, err = OpenDurableCircularQueue[int64](path, 3, SyncNever)
+	require.NoError(t, err)
+	defer queue.Close()
+
+	assert.True(t, queue.Full())
+	assert.Equal(t, int64(-2), queue.Front())
+	assert.Equal(t, int64(4), queue.Back())
+
+	var values []int64
+	for !queue.Empty() {
+		values = append(values, queue.Front())
+		assert.True(t, queue.Pop())
+	}
+	assert.Equal(t, []int64{-2, 3, 4}, values)
+
+	_, err = OpenDurableCircularQueue[int64](path, 4, SyncNever)
+	assert.ErrorIs(t, err, ErrSizeMismatch)
+}
+
+func TestDurableCircularQueueTornSlot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue")
+	queue, err := OpenDurableCircularQueue[int32](path, 4, SyncNever)
+	require.NoError(t, err)
+
+	for i := int32(1); i <= 3; i++ {
+		assert.True(t, queue.Push(i))
+	}
+	require.NoError(t, queue.Close())
+
+	corruptFile(t, path, headersSize+2*slotSize+10)
+
+	queue, err = OpenDurableCircularQueue[int32](path, 4, SyncNever)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), queue.Front())
+	assert.Equal(t, int32(2), queue.Back())
+
+	assert.True(t, queue.Push(5))
+	assert.Equal(t, int32(5), queue.Back())
+	require.NoError(t, queue.Close())
+}
+
+func TestDurableCircularQueueTornHeader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue")
+	queue, err := OpenDurableCircularQueue[int](path, 4, SyncNever)
+	require.NoError(t, err)
+
+	assert.True(t, queue.Push(1))
+	assert.True(t, queue.Push(2))
+	latestHeader := int64(queue.sequence%2) * headerSize
+	require.NoError(t, queue.Close())
+
+	corruptFile(t, path, latestHeader+20)
+
+	queue, err = OpenDurableCircularQueue[int](path, 4, SyncNever)
+	require.NoError(t, err)
+	assert.Equal(t, 1, queue.Front())
+	assert.Equal(t, 1, queue.Back())
+	require.NoError(t, queue.Close())
+
+	corruptFile(t, path, 4)
+	corruptFile(t, path, headerSize+4)
+	_, err = OpenDurableCircularQueue[int](path, 4, SyncNever)
+	assert.ErrorIs(t, err, ErrCorruptedQueue)
+}
+
+func TestDurableCircularQueueLostPop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue")
+	queue, err := OpenDurableCircularQueue[int](path, 3, SyncNever)
+	require.NoError(t, err)
+
+	for i := 1; i <= 3; i++ {
+		assert.True(t, queue.Push(i))
+	}
+	staleHeaders := readFileRange(t, path, 0, headersSize)
+
+	// the Pops are followed by a Push into the freed slot, then the headers
+	// are rolled back as if they had never reached the disk
+	assert.True(t, queue.Pop())
+	assert.True(t, queue.Pop())
+	assert.True(t, queue.Push(4))
+	require.NoError(t, queue.Close())
+	writeFileRange(t, path, 0, staleHeaders)
+
+	queue, err = OpenDurableCircularQueue[int](path, 3, SyncNever)
+	require.NoError(t, err)
+
+	var values []int
+	for !queue.Empty() {
+		values = append(values, queue.Front())
+		assert.True(t, queue.Pop())
+	}
+	// 2 was popped too, but only the Pop of 1 left evidence on disk
+	assert.Equal(t, []int{2, 3}, values)
+	require.NoError(t, queue.Close())
+}
+
+func TestDurableCircularQueueSyncEvery(t *testing.T) {
+	assert.Equal(t, SyncAlways, SyncEvery(1))
+	assert.Equal(t, SyncAlways, SyncEvery(0))
+
+	path := filepath.Join(t.TempDir(), "queue")
+	queue, err := OpenDurableCircularQueue[int](path, 8, SyncEvery(3))
+	require.NoError(t, err)
+
+	assert.True(t, queue.Push(1))
+	assert.Equal(t, 1, queue.unsynced)
+	assert.True(t, queue.Push(2))
+	assert.Equal(t, 2, queue.unsynced)
+	assert.True(t, queue.Pop())
+	assert.Equal(t, 0, queue.unsynced)
+	assert.True(t, queue.Push(3))
+	assert.Equal(t, 1, queue.unsynced)
+	require.NoError(t, queue.Sync())
+	assert.Equal(t, 0, queue.unsynced)
+	require.NoError(t, queue.Close())
+
+	queue, err = OpenDurableCircularQueue[int](path, 8, SyncEvery(3))
+	require.NoError(t, err)
+	assert.Equal(t, 2, queue.Front())
+	assert.Equal(t, 3, queue.Back())
+	require.NoError(t, queue.Close())
+}
+
+func readFileRange(t *testing.T, path string, offset, length int64) []byte {
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	data := make([]byte, length)
+	_, err = file.ReadAt(data, offset)
+	require.NoError(t, err)
+
+	return data
+}
+
+func writeFileRange(t *testing.T, path string, offset int64, data []byte) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	require.NoError(t, err)
+	defer file.Close()
+
+	_, err = file.WriteAt(data, offset)
+	require.NoError(t, err)
+}
+
+func corruptFile(t *testing.T, path string, offset int64) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var b [1]byte
+	_, err = file.ReadAt(b[:], offset)
+	require.NoError(t, err)
+
+	b[0] ^= 0xFF
+	_, err = file.WriteAt(b[:], offset)
+	require.NoError(t, err)
+}
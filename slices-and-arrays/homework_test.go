@@ -9,7 +9,11 @@ import (
 
 // go test -v homework_test.go
 
-type CircularQueue[T int | int8 | int16 | int32 | int64] struct {
+type Integer interface {
+	int | int8 | int16 | int32 | int64
+}
+
+type CircularQueue[T Integer] struct {
 	values []T
 
 	size  int
@@ -17,9 +21,12 @@ type CircularQueue[T int | int8 | int16 | int32 | int64] struct {
 
 	front int
 	rear  int
+
+	overwrite bool
+	onEvict   func(T)
 }
 
-func NewCircularQueue[T int | int8 | int16 | int32 | int64](size int) CircularQueue[T] {
+func NewCircularQueue[T Integer](size int) CircularQueue[T] {
 	return CircularQueue[T]{
 		size:   size,
 		rear:   -1,
@@ -27,9 +34,28 @@ func NewCircularQueue[T int | int8 | int16 | int32 | int64](size int) CircularQu
 	}
 }
 
+// NewOverwritingCircularQueue returns a queue that always keeps the most
+// recent size values: Push on a full queue evicts the front value and
+// passes it to onEvict, if one is given
+func NewOverwritingCircularQueue[T Integer](size int, onEvict func(T)) CircularQueue[T] {
+	queue := NewCircularQueue[T](size)
+	queue.overwrite = true
+	queue.onEvict = onEvict
+
+	return queue
+}
+
 func (q *CircularQueue[T]) Push(value T) bool {
 	if q.Full() {
-		return false
+		if !q.overwrite || q.size == 0 {
+			return false
+		}
+
+		evicted := q.values[q.front]
+		q.Pop()
+		if q.onEvict != nil {
+			q.onEvict(evicted)
+		}
 	}
 
 	q.rear = (q.rear + 1) % q.size
@@ -50,6 +76,134 @@ func (q *CircularQueue[T]) Pop() bool {
 	return true
 }
 
+// PushFront never evicts, even in overwrite mode
+func (q *CircularQueue[T]) PushFront(value T) bool {
+	if q.Full() {
+		return false
+	}
+
+	q.front = (q.front - 1 + q.size) % q.size
+	q.values[q.front] = value
+	q.count += 1
+	q.rear = (q.front + q.count - 1) % q.size
+
+	return true
+}
+
+func (q *CircularQueue[T]) PopBack() bool {
+	if q.Empty() {
+		return false
+	}
+
+	q.rear = (q.rear - 1 + q.size) % q.size
+	q.count -= 1
+
+	return true
+}
+
+// At returns the i-th value counting from the front
+func (q *CircularQueue[T]) At(i int) T {
+	if i < 0 || i >= q.count {
+		return -1
+	}
+
+	return q.values[(q.front+i)%q.size]
+}
+
+func (q *CircularQueue[T]) Set(i int, value T) bool {
+	if i < 0 || i >= q.count {
+		return false
+	}
+
+	q.values[(q.front+i)%q.size] = value
+	return true
+}
+
+func (q *CircularQueue[T]) Clear() {
+	q.front = 0
+	q.rear = -1
+	q.count = 0
+}
+
+// PushN pushes as many values as fit and returns their number. Unlike Push
+// it never evicts values, even in overwrite mode
+func (q *CircularQueue[T]) PushN(values []T) int {
+	first, second := q.WritableSegments()
+	n := copy(first, values)
+	n += copy(second, values[n:])
+	q.CommitWrite(n)
+
+	return n
+}
+
+// PopN moves up to len(dst) values from the front into dst
+func (q *CircularQueue[T]) PopN(dst []T) int {
+	first, second := q.ReadableSegments()
+	n := copy(dst, first)
+	n += copy(dst[n:], second)
+	q.CommitRead(n)
+
+	return n
+}
+
+// WritableSegments returns the free space after the back of the queue as up
+// to two slices. Values written there become visible after CommitWrite
+func (q *CircularQueue[T]) WritableSegments() ([]T, []T) {
+	free := q.size - q.count
+	if free == 0 {
+		return nil, nil
+	}
+
+	start := (q.front + q.count) % q.size
+	if start+free <= q.size {
+		return q.values[start : start+free], nil
+	}
+
+	return q.values[start:], q.values[:free-(q.size-start)]
+}
+
+// ReadableSegments returns the queued values in order as up to two slices.
+// They stay in the queue until CommitRead
+func (q *CircularQueue[T]) ReadableSegments() ([]T, []T) {
+	if q.Empty() {
+		return nil, nil
+	}
+
+	if q.front+q.count <= q.size {
+		return q.values[q.front : q.front+q.count], nil
+	}
+
+	return q.values[q.front:], q.values[:q.count-(q.size-q.front)]
+}
+
+// CommitWrite appends n values already written to the writable segments
+func (q *CircularQueue[T]) CommitWrite(n int) bool {
+	if n < 0 || n > q.size-q.count {
+		return false
+	}
+
+	if n > 0 {
+		q.count += n
+		q.rear = (q.front + q.count - 1) % q.size
+	}
+
+	return true
+}
+
+// CommitRead removes n values from the front
+func (q *CircularQueue[T]) CommitRead(n int) bool {
+	if n < 0 || n > q.count {
+		return false
+	}
+
+	if n > 0 {
+		q.front = (q.front + n) % q.size
+		q.count -= n
+	}
+
+	return true
+}
+
 func (q *CircularQueue[T]) Front() T {
 	if q.Empty() {
 		return -1
@@ -66,6 +220,44 @@ func (q *CircularQueue[T]) Back() T {
 	return q.values[q.rear]
 }
 
+// ForEach visits the values from front to back until action returns false
+func (q *CircularQueue[T]) ForEach(action func(T) bool) {
+	first, second := q.ReadableSegments()
+	for _, segment := range [][]T{first, second} {
+		for _, value := range segment {
+			if !action(value) {
+				return
+			}
+		}
+	}
+}
+
+// ToSlice returns a copy of the values from front to back
+func (q *CircularQueue[T]) ToSlice() []T {
+	values := make([]T, q.count)
+	first, second := q.ReadableSegments()
+	n := copy(values, first)
+	copy(values[n:], second)
+
+	return values
+}
+
+// Drain removes all values and returns them from front to back
+func (q *CircularQueue[T]) Drain() []T {
+	values := q.ToSlice()
+	q.Clear()
+
+	return values
+}
+
+func (q *CircularQueue[T]) Len() int {
+	return q.count
+}
+
+func (q *CircularQueue[T]) Cap() int {
+	return q.size
+}
+
 func (q *CircularQueue[T]) Empty() bool {
 	return q.count == 0
 }
@@ -90,7 +282,7 @@ func TestCircularQueue(t *testing.T) {
 	assert.True(t, queue.Push(3))
 	assert.False(t, queue.Push(4))
 
-	assert.True(t, reflect.DeepEqual([]int{1, 2, 3}, queue.values))
+	assert.True(t, reflect.DeepEqual([]int{1, 2, 3}, queue.ToSlice()))
 
 	assert.False(t, queue.Empty())
 	assert.True(t, queue.Full())
@@ -103,7 +295,7 @@ func TestCircularQueue(t *testing.T) {
 	assert.False(t, queue.Full())
 	assert.True(t, queue.Push(4))
 
-	assert.True(t, reflect.DeepEqual([]int{4, 2, 3}, queue.values))
+	assert.True(t, reflect.DeepEqual([]int{2, 3, 4}, queue.ToSlice()))
 
 	assert.Equal(t, 2, queue.Front())
 	assert.Equal(t, 4, queue.Back())
@@ -116,3 +308,199 @@ func TestCircularQueue(t *testing.T) {
 	assert.True(t, queue.Empty())
 	assert.False(t, queue.Full())
 }
+
+func TestOverwritingCircularQueue(t *testing.T) {
+	const queueSize = 3
+	var evicted []int
+	queue := NewOverwritingCircularQueue[int](queueSize, func(value int) {
+		evicted = append(evicted, value)
+	})
+
+	assert.True(t, queue.Push(1))
+	assert.True(t, queue.Push(2))
+	assert.True(t, queue.Push(3))
+	assert.True(t, queue.Full())
+	assert.Empty(t, evicted)
+
+	assert.True(t, queue.Push(4))
+	assert.True(t, queue.Push(5))
+	assert.True(t, queue.Full())
+	assert.Equal(t, []int{1, 2}, evicted)
+
+	assert.Equal(t, 3, queue.Front())
+	assert.Equal(t, 5, queue.Back())
+	assert.True(t, reflect.DeepEqual([]int{3, 4, 5}, queue.ToSlice()))
+
+	assert.True(t, queue.Pop())
+	assert.True(t, queue.Push(6))
+	assert.Equal(t, []int{1, 2}, evicted)
+	assert.Equal(t, 4, queue.Front())
+	assert.Equal(t, 6, queue.Back())
+
+	withoutCallback := NewOverwritingCircularQueue[int8](1, nil)
+	assert.True(t, withoutCallback.Push(1))
+	assert.True(t, withoutCallback.Push(2))
+	assert.Equal(t, int8(2), withoutCallback.Front())
+
+	empty := NewOverwritingCircularQueue[int](0, nil)
+	assert.False(t, empty.Push(1))
+}
+
+func TestCircularQueueDeque(t *testing.T) {
+	const queueSize = 4
+	queue := NewCircularQueue[int](queueSize)
+
+	assert.False(t, queue.PopBack())
+	assert.Equal(t, -1, queue.At(0))
+	assert.False(t, queue.Set(0, 1))
+
+	assert.True(t, queue.PushFront(2))
+	assert.Equal(t, 2, queue.Front())
+	assert.Equal(t, 2, queue.Back())
+
+	assert.True(t, queue.PushFront(1))
+	assert.True(t, queue.Push(3))
+	assert.True(t, queue.Push(4))
+	assert.False(t, queue.PushFront(0))
+	assert.True(t, reflect.DeepEqual([]int{1, 2, 3, 4}, queue.ToSlice()))
+
+	for i, expected := range []int{1, 2, 3, 4} {
+		assert.Equal(t, expected, queue.At(i))
+	}
+	assert.Equal(t, -1, queue.At(-1))
+	assert.Equal(t, -1, queue.At(queueSize))
+
+	assert.True(t, queue.Set(2, 30))
+	assert.Equal(t, 30, queue.At(2))
+
+	assert.True(t, queue.PopBack())
+	assert.Equal(t, 30, queue.Back())
+	assert.True(t, queue.Pop())
+	assert.Equal(t, 2, queue.Front())
+	assert.True(t, queue.PopBack())
+	assert.True(t, queue.PopBack())
+	assert.False(t, queue.PopBack())
+	assert.True(t, queue.Empty())
+
+	assert.True(t, queue.Push(5))
+	assert.True(t, queue.PushFront(6))
+	queue.Clear()
+	assert.True(t, queue.Empty())
+	assert.Equal(t, -1, queue.Front())
+	assert.Equal(t, -1, queue.Back())
+
+	assert.True(t, queue.Push(7))
+	assert.Equal(t, 7, queue.Front())
+	assert.Equal(t, 7, queue.Back())
+}
+
+func TestCircularQueueBatch(t *testing.T) {
+	const queueSize = 5
+	queue := NewCircularQueue[int](queueSize)
+
+	buffer := make([]int, queueSize)
+	assert.Zero(t, queue.PopN(buffer))
+
+	assert.Equal(t, 3, queue.PushN([]int{1, 2, 3}))
+	assert.Equal(t, 2, queue.PopN(buffer[:2]))
+	assert.Equal(t, []int{1, 2}, buffer[:2])
+
+	assert.Equal(t, 4, queue.PushN([]int{4, 5, 6, 7, 8}))
+	assert.True(t, queue.Full())
+	assert.True(t, reflect.DeepEqual([]int{3, 4, 5, 6, 7}, queue.ToSlice()))
+	assert.Equal(t, 3, queue.Front())
+	assert.Equal(t, 7, queue.Back())
+	assert.Zero(t, queue.PushN([]int{9}))
+
+	first, second := queue.ReadableSegments()
+	assert.Equal(t, []int{3, 4, 5}, first)
+	assert.Equal(t, []int{6, 7}, second)
+
+	assert.Equal(t, queueSize, queue.PopN(buffer))
+	assert.Equal(t, []int{3, 4, 5, 6, 7}, buffer)
+	assert.True(t, queue.Empty())
+
+	empty := NewCircularQueue[int](0)
+	assert.Zero(t, empty.PushN([]int{1}))
+	assert.Zero(t, empty.PopN(buffer))
+	assert.True(t, empty.CommitWrite(0))
+	assert.True(t, empty.CommitRead(0))
+}
+
+func TestCircularQueueSegments(t *testing.T) {
+	const queueSize = 4
+	queue := NewCircularQueue[int8](queueSize)
+
+	first, second := queue.WritableSegments()
+	assert.Len(t, first, queueSize)
+	assert.Nil(t, second)
+
+	first[0], first[1], first[2] = 1, 2, 3
+	assert.False(t, queue.CommitWrite(queueSize+1))
+	assert.True(t, queue.CommitWrite(3))
+	assert.Equal(t, int8(1), queue.Front())
+	assert.Equal(t, int8(3), queue.Back())
+
+	assert.False(t, queue.CommitRead(4))
+	assert.True(t, queue.CommitRead(2))
+	assert.Equal(t, int8(3), queue.Front())
+
+	first, second = queue.WritableSegments()
+	assert.Len(t, first, 1)
+	assert.Len(t, second, 2)
+	first[0], second[0], second[1] = 4, 5, 6
+	assert.True(t, queue.CommitWrite(3))
+	assert.True(t, queue.Full())
+
+	first, second = queue.WritableSegments()
+	assert.Nil(t, first)
+	assert.Nil(t, second)
+
+	assert.Equal(t, []int8{3, 4, 5, 6}, queue.Drain())
+	assert.True(t, queue.Empty())
+}
+
+func TestCircularQueueIteration(t *testing.T) {
+	const queueSize = 4
+	queue := NewCircularQueue[int](queueSize)
+
+	assert.Equal(t, queueSize, queue.Cap())
+	assert.Zero(t, queue.Len())
+	assert.Empty(t, queue.ToSlice())
+	assert.Empty(t, queue.Drain())
+
+	queue.ForEach(func(int) bool {
+		assert.Fail(t, "unexpected value in empty queue")
+		return true
+	})
+
+	for i := 1; i <= queueSize; i++ {
+		queue.Push(i)
+	}
+	queue.Pop()
+	queue.Pop()
+	queue.Push(5)
+	assert.Equal(t, 3, queue.Len())
+
+	var values []int
+	queue.ForEach(func(value int) bool {
+		values = append(values, value)
+		return true
+	})
+	assert.Equal(t, []int{3, 4, 5}, values)
+
+	values = nil
+	queue.ForEach(func(value int) bool {
+		values = append(values, value)
+		return value < 4
+	})
+	assert.Equal(t, []int{3, 4}, values)
+
+	snapshot := queue.ToSlice()
+	snapshot[0] = 100
+	assert.Equal(t, 3, queue.Front())
+
+	assert.Equal(t, []int{3, 4, 5}, queue.Drain())
+	assert.True(t, queue.Empty())
+	assert.Equal(t, queueSize, queue.Cap())
+}
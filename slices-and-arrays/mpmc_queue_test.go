@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mpmcSlot[T Integer] struct {
+	sequence atomic.Uint64
+	value    T
+}
+
+// MPMCQueue is a bounded queue safe for any number of producers and
+// consumers. Every slot carries a sequence number telling whether it is
+// ready to be written (sequence == position) or read (sequence == position+1)
+type MPMCQueue[T Integer] struct {
+	_    [cacheLineSize]byte
+	head atomic.Uint64
+	_    [cacheLineSize - 8]byte
+	tail atomic.Uint64
+	_    [cacheLineSize - 8]byte
+
+	slots []mpmcSlot[T]
+	mask  uint64
+}
+
+// NewMPMCQueue rounds size up to the next power of two. The capacity is
+// at least 2, otherwise a written slot would look free for the next position
+func NewMPMCQueue[T Integer](size int) *MPMCQueue[T] {
+	capacity := 2
+	for capacity < size {
+		capacity <<= 1
+	}
+
+	q := &MPMCQueue[T]{
+		slots: make([]mpmcSlot[T], capacity),
+		mask:  uint64(capacity - 1),
+	}
+	for i := range q.slots {
+		q.slots[i].sequence.Store(uint64(i))
+	}
+
+	return q
+}
+
+func (q *MPMCQueue[T]) TryPush(value T) bool {
+	for {
+		tail := q.tail.Load()
+		slot := &q.slots[tail&q.mask]
+		sequence := slot.sequence.Load()
+
+		switch {
+		case sequence == tail:
+			if q.tail.CompareAndSwap(tail, tail+1) {
+				slot.value = value
+				slot.sequence.Store(tail + 1)
+				return true
+			}
+		case sequence < tail:
+			return false
+		}
+	}
+}
+
+func (q *MPMCQueue[T]) TryPop() (T, bool) {
+	for {
+		head := q.head.Load()
+		slot := &q.slots[head&q.mask]
+		sequence := slot.sequence.Load()
+
+		switch {
+		case sequence == head+1:
+			if q.head.CompareAndSwap(head, head+1) {
+				value := slot.value
+				slot.sequence.Store(head + q.mask + 1)
+				return value, true
+			}
+		case sequence < head+1:
+			var zero T
+			return zero, false
+		}
+	}
+}
+
+// Push blocks until there is room for value or ctx is done
+func (q *MPMCQueue[T]) Push(ctx context.Context, value T) error {
+	for attempt := 0; ; attempt++ {
+		if q.TryPush(value) {
+			return nil
+		}
+		if err := waitRetry(ctx, attempt); err != nil {
+			return err
+		}
+	}
+}
+
+// Pop blocks until a value is available or ctx is done
+func (q *MPMCQueue[T]) Pop(ctx context.Context) (T, error) {
+	for attempt := 0; ; attempt++ {
+		if value, ok := q.TryPop(); ok {
+			return value, nil
+		}
+		if err := waitRetry(ctx, attempt); err != nil {
+			var zero T
+			return zero, err
+		}
+	}
+}
+
+func (q *MPMCQueue[T]) Cap() int {
+	return len(q.slots)
+}
+
+const (
+	spinAttempts = 64
+	maxBackoff   = time.Millisecond
+)
+
+func waitRetry(ctx context.Context, attempt int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if attempt < spinAttempts {
+		runtime.Gosched()
+		return nil
+	}
+
+	backoff := time.Microsecond << min(attempt-spinAttempts, 10)
+	timer := time.NewTimer(min(backoff, maxBackoff))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func TestMPMCQueue(t *testing.T) {
+	queue := NewMPMCQueue[int](2)
+	assert.Equal(t, 2, queue.Cap())
+
+	_, ok := queue.TryPop()
+	assert.False(t, ok)
+
+	assert.True(t, queue.TryPush(1))
+	assert.True(t, queue.TryPush(2))
+	assert.False(t, queue.TryPush(3))
+
+	value, ok := queue.TryPop()
+	assert.True(t, ok)
+	assert.Equal(t, 1, value)
+
+	assert.True(t, queue.TryPush(3))
+
+	value, ok = queue.TryPop()
+	assert.True(t, ok)
+	assert.Equal(t, 2, value)
+	value, ok = queue.TryPop()
+	assert.True(t, ok)
+	assert.Equal(t, 3, value)
+
+	_, ok = queue.TryPop()
+	assert.False(t, ok)
+}
+
+func TestMPMCQueueCancellation(t *testing.T) {
+	queue := NewMPMCQueue[int](1)
+	assert.Equal(t, 2, queue.Cap())
+	assert.NoError(t, queue.Push(context.Background(), 1))
+	assert.NoError(t, queue.Push(context.Background(), 2))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, queue.Push(ctx, 3), context.DeadlineExceeded)
+
+	value, err := queue.Pop(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, value)
+	value, err = queue.Pop(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, value)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = queue.Pop(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestMPMCQueueConcurrent(t *testing.T) {
+	const producersNumber = 4
+	const consumersNumber = 4
+	const itemsPerProducer = 10_000
+
+	queue := NewMPMCQueue[int64](16)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	wg.Add(producersNumber)
+	for p := 0; p < producersNumber; p++ {
+		go func() {
+			defer wg.Done()
+			for i := 1; i <= itemsPerProducer; i++ {
+				_ = queue.Push(ctx, int64(i))
+			}
+		}()
+	}
+
+	var sum atomic.Int64
+	var consumers sync.WaitGroup
+	consumers.Add(consumersNumber)
+	for c := 0; c < consumersNumber; c++ {
+		go func() {
+			defer consumers.Done()
+			for i := 0; i < producersNumber*itemsPerProducer/consumersNumber; i++ {
+				value, _ := queue.Pop(ctx)
+				sum.Add(value)
+			}
+		}()
+	}
+
+	wg.Wait()
+	consumers.Wait()
+
+	expected := int64(producersNumber * itemsPerProducer * (itemsPerProducer + 1) / 2)
+	assert.Equal(t, expected, sum.Load())
+	_, ok := queue.TryPop()
+	assert.False(t, ok)
+}
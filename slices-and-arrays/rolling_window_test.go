@@ -0,0 +1,264 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// RollingWindow keeps the last size samples and their aggregates. Min and
+// max are tracked with monotonic deques, so every operation is O(1) amortized.
+// Mean and variance are maintained with Welford's updates over samples
+// shifted by the first sample, so they neither overflow nor lose precision
+// for large samples that are close to each other
+type RollingWindow[T Integer] struct {
+	samples CircularQueue[T]
+	minimum CircularQueue[T]
+	maximum CircularQueue[T]
+
+	sum   int64
+	shift int64
+	// mean is the mean of the shifted samples and m2 is the sum of their
+	// squared deviations from it
+	mean float64
+	m2   float64
+}
+
+func NewRollingWindow[T Integer](size int) *RollingWindow[T] {
+	w := &RollingWindow[T]{
+		minimum: NewCircularQueue[T](size),
+		maximum: NewCircularQueue[T](size),
+	}
+	w.samples = NewOverwritingCircularQueue[T](size, w.evict)
+
+	return w
+}
+
+// Push adds value, evicting the oldest sample when the window is full
+func (w *RollingWindow[T]) Push(value T) {
+	if !w.samples.Push(value) {
+		return
+	}
+
+	w.sum += int64(value)
+	if w.samples.Len() == 1 {
+		w.shift = int64(value)
+	}
+
+	shifted := w.shifted(value)
+	delta := shifted - w.mean
+	w.mean += delta / float64(w.samples.Len())
+	w.m2 += delta * (shifted - w.mean)
+
+	for !w.minimum.Empty() && w.minimum.Back() > value {
+		w.minimum.PopBack()
+	}
+	w.minimum.Push(value)
+
+	for !w.maximum.Empty() && w.maximum.Back() < value {
+		w.maximum.PopBack()
+	}
+	w.maximum.Push(value)
+}
+
+// Pop removes the oldest sample
+func (w *RollingWindow[T]) Pop() bool {
+	if w.samples.Empty() {
+		return false
+	}
+
+	value := w.samples.Front()
+	w.samples.Pop()
+	w.evict(value)
+
+	return true
+}
+
+// evict is called after value has left samples
+func (w *RollingWindow[T]) evict(value T) {
+	w.sum -= int64(value)
+
+	if w.samples.Empty() {
+		w.mean, w.m2 = 0, 0
+	} else {
+		shifted := w.shifted(value)
+		delta := shifted - w.mean
+		w.mean -= delta / float64(w.samples.Len())
+		w.m2 = max(w.m2-delta*(shifted-w.mean), 0)
+	}
+
+	if w.minimum.Front() == value {
+		w.minimum.Pop()
+	}
+	if w.maximum.Front() == value {
+		w.maximum.Pop()
+	}
+}
+
+// shifted returns value - shift, falling back to float64 arithmetic when the
+// difference doesn't fit into int64
+func (w *RollingWindow[T]) shifted(value T) float64 {
+	difference := int64(value) - w.shift
+	if (int64(value) >= 0) != (w.shift >= 0) && (difference >= 0) != (int64(value) >= 0) {
+		return float64(value) - float64(w.shift)
+	}
+
+	return float64(difference)
+}
+
+func (w *RollingWindow[T]) Len() int {
+	return w.samples.Len()
+}
+
+// Sum is exact as long as the sum of the window fits into int64, which
+// always holds for samples up to int32 and windows up to 2^32 samples
+func (w *RollingWindow[T]) Sum() int64 {
+	return w.sum
+}
+
+func (w *RollingWindow[T]) Mean() float64 {
+	if w.samples.Empty() {
+		return math.NaN()
+	}
+
+	return float64(w.shift) + w.mean
+}
+
+// Variance returns the population variance of the samples
+func (w *RollingWindow[T]) Variance() float64 {
+	if w.samples.Empty() {
+		return math.NaN()
+	}
+
+	return w.m2 / float64(w.samples.Len())
+}
+
+// Min returns false for an empty window
+func (w *RollingWindow[T]) Min() (T, bool) {
+	if w.minimum.Empty() {
+		return 0, false
+	}
+
+	return w.minimum.Front(), true
+}
+
+// Max returns false for an empty window
+func (w *RollingWindow[T]) Max() (T, bool) {
+	if w.maximum.Empty() {
+		return 0, false
+	}
+
+	return w.maximum.Front(), true
+}
+
+func TestRollingWindow(t *testing.T) {
+	window := NewRollingWindow[int](3)
+
+	assert.Zero(t, window.Len())
+	assert.True(t, math.IsNaN(window.Mean()))
+	assert.True(t, math.IsNaN(window.Variance()))
+	_, found := window.Min()
+	assert.False(t, found)
+	_, found = window.Max()
+	assert.False(t, found)
+	assert.False(t, window.Pop())
+
+	window.Push(4)
+	window.Push(2)
+	window.Push(6)
+	assert.Equal(t, 3, window.Len())
+	assert.Equal(t, int64(12), window.Sum())
+	assert.InDelta(t, 4.0, window.Mean(), 1e-9)
+	assert.InDelta(t, 8.0/3, window.Variance(), 1e-9)
+	assertExtremes(t, window, 2, 6)
+
+	window.Push(1)
+	assert.Equal(t, 3, window.Len())
+	assert.Equal(t, int64(9), window.Sum())
+	assertExtremes(t, window, 1, 6)
+
+	window.Push(3)
+	window.Push(3)
+	assert.Equal(t, int64(7), window.Sum())
+	assertExtremes(t, window, 1, 3)
+
+	assert.True(t, window.Pop())
+	assert.Equal(t, 2, window.Len())
+	assertExtremes(t, window, 3, 3)
+	assert.InDelta(t, 0, window.Variance(), 1e-9)
+
+	assert.True(t, window.Pop())
+	assert.True(t, window.Pop())
+	assert.Zero(t, window.Sum())
+	_, found = window.Min()
+	assert.False(t, found)
+
+	window.Push(-1)
+	assertExtremes(t, window, -1, -1)
+}
+
+func TestRollingWindowLargeSamples(t *testing.T) {
+	const base = math.MaxInt64 / 4
+	window := NewRollingWindow[int64](3)
+
+	for _, offset := range []int64{0, 1, 2, 3, 4} {
+		window.Push(base + offset)
+	}
+
+	assertExtremes(t, window, base+2, base+4)
+	assert.InDelta(t, 2.0/3, window.Variance(), 1e-9)
+	assert.InEpsilon(t, float64(base), window.Mean(), 1e-12)
+
+	assert.True(t, window.Pop())
+	assert.True(t, window.Pop())
+	assert.Zero(t, window.Variance())
+
+	extremes := NewRollingWindow[int64](2)
+	extremes.Push(math.MinInt64)
+	extremes.Push(math.MaxInt64)
+	assert.InEpsilon(t, math.Pow(2, 126), extremes.Variance(), 1e-12)
+	assert.InDelta(t, 0, extremes.Mean(), 1)
+}
+
+func TestRollingWindowAgainstScan(t *testing.T) {
+	const windowSize = 7
+	samples := []int16{5, -3, 8, 8, 0, 12, -7, 4, 4, 4, 9, -1, 15, 2, 2, -9, 6, 3, 11, 0}
+	window := NewRollingWindow[int16](windowSize)
+
+	for i, sample := range samples {
+		window.Push(sample)
+
+		last := samples[max(0, i+1-windowSize) : i+1]
+		sum, minimum, maximum := int64(0), last[0], last[0]
+		for _, value := range last {
+			sum += int64(value)
+			minimum = min(minimum, value)
+			maximum = max(maximum, value)
+		}
+
+		mean := float64(sum) / float64(len(last))
+		variance := 0.0
+		for _, value := range last {
+			variance += (float64(value) - mean) * (float64(value) - mean)
+		}
+		variance /= float64(len(last))
+
+		assert.Equal(t, sum, window.Sum())
+		assertExtremes(t, window, minimum, maximum)
+		assert.InDelta(t, mean, window.Mean(), 1e-9)
+		assert.InDelta(t, variance, window.Variance(), 1e-9)
+	}
+}
+
+func assertExtremes[T Integer](t *testing.T, window *RollingWindow[T], minimum, maximum T) {
+	t.Helper()
+
+	value, found := window.Min()
+	assert.True(t, found)
+	assert.Equal(t, minimum, value)
+
+	value, found = window.Max()
+	assert.True(t, found)
+	assert.Equal(t, maximum, value)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"runtime"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const cacheLineSize = 64
+
+// SPSCQueue is a lock-free ring buffer for exactly one producer goroutine
+// and one consumer goroutine. head is written only by the consumer and tail
+// only by the producer, each on its own cache line to avoid false sharing
+type SPSCQueue[T Integer] struct {
+	_    [cacheLineSize]byte
+	head atomic.Uint64
+	_    [cacheLineSize - 8]byte
+	tail atomic.Uint64
+	_    [cacheLineSize - 8]byte
+
+	values []T
+	mask   uint64
+}
+
+// NewSPSCQueue rounds size up to the next power of two
+func NewSPSCQueue[T Integer](size int) *SPSCQueue[T] {
+	capacity := 1
+	for capacity < size {
+		capacity <<= 1
+	}
+
+	return &SPSCQueue[T]{
+		values: make([]T, capacity),
+		mask:   uint64(capacity - 1),
+	}
+}
+
+// TryPush must only be called from the producer goroutine
+func (q *SPSCQueue[T]) TryPush(value T) bool {
+	tail := q.tail.Load()
+	if tail-q.head.Load() == uint64(len(q.values)) {
+		return false
+	}
+
+	q.values[tail&q.mask] = value
+	q.tail.Store(tail + 1)
+
+	return true
+}
+
+// TryPop must only be called from the consumer goroutine
+func (q *SPSCQueue[T]) TryPop() (T, bool) {
+	head := q.head.Load()
+	if head == q.tail.Load() {
+		var zero T
+		return zero, false
+	}
+
+	value := q.values[head&q.mask]
+	q.head.Store(head + 1)
+
+	return value, true
+}
+
+// Len is only a snapshot while the queue is in use. head is loaded first,
+// so the consumer can't move it past the loaded tail
+func (q *SPSCQueue[T]) Len() int {
+	head := q.head.Load()
+	tail := q.tail.Load()
+
+	return min(int(tail-head), len(q.values))
+}
+
+func (q *SPSCQueue[T]) Cap() int {
+	return len(q.values)
+}
+
+func TestSPSCQueue(t *testing.T) {
+	queue := NewSPSCQueue[int](3)
+	assert.Equal(t, 4, queue.Cap())
+	assert.Zero(t, queue.Len())
+
+	_, ok := queue.TryPop()
+	assert.False(t, ok)
+
+	for i := 1; i <= 4; i++ {
+		assert.True(t, queue.TryPush(i))
+	}
+	assert.False(t, queue.TryPush(5))
+	assert.Equal(t, 4, queue.Len())
+
+	value, ok := queue.TryPop()
+	assert.True(t, ok)
+	assert.Equal(t, 1, value)
+	assert.True(t, queue.TryPush(5))
+
+	for i := 2; i <= 5; i++ {
+		value, ok = queue.TryPop()
+		assert.True(t, ok)
+		assert.Equal(t, i, value)
+	}
+
+	_, ok = queue.TryPop()
+	assert.False(t, ok)
+}
+
+func TestSPSCQueueConcurrent(t *testing.T) {
+	const itemsNumber = 100_000
+	queue := NewSPSCQueue[int](64)
+
+	go func() {
+		for i := 0; i < itemsNumber; i++ {
+			for !queue.TryPush(i) {
+				runtime.Gosched()
+			}
+		}
+	}()
+
+	for i := 0; i < itemsNumber; i++ {
+		if length := queue.Len(); length < 0 || length > queue.Cap() {
+			t.Fatalf("unexpected length %d", length)
+		}
+
+		value, ok := queue.TryPop()
+		for !ok {
+			runtime.Gosched()
+			value, ok = queue.TryPop()
+		}
+		if value != i {
+			t.Fatalf("expected %d, got %d", i, value)
+		}
+	}
+}
+
+func BenchmarkSPSCQueue(b *testing.B) {
+	queue := NewSPSCQueue[int](1024)
+
+	go func() {
+		for i := 0; i < b.N; i++ {
+			for !queue.TryPush(i) {
+				runtime.Gosched()
+			}
+		}
+	}()
+
+	for i := 0; i < b.N; i++ {
+		for {
+			if _, ok := queue.TryPop(); ok {
+				break
+			}
+			runtime.Gosched()
+		}
+	}
+}
+
+func BenchmarkBufferedChannel(b *testing.B) {
+	ch := make(chan int, 1024)
+
+	go func() {
+		for i := 0; i < b.N; i++ {
+			ch <- i
+		}
+	}()
+
+	for i := 0; i < b.N; i++ {
+		<-ch
+	}
+}
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"math"
+	"strings"
+	"sync"
 	"testing"
+	"unicode/utf8"
 	"unsafe"
 
 	"github.com/stretchr/testify/assert"
@@ -31,88 +36,158 @@ const (
 	levelMask       = 0x000F
 )
 
+var (
+	ErrOutOfRange  = errors.New("value is out of range")
+	ErrNameTooLong = errors.New("name is too long")
+)
+
+// Option sets fields of a GamePerson. A With* option given an invalid value
+// leaves the field unchanged and panics with an optionError, which
+// NewGamePerson, NewGamePersonChecked and Apply recover from every option
+// they run, so errors of options applied from inside other options stay with
+// their own call. Other panics are passed on
 type Option func(*GamePerson)
 
+// optionError carries the error of a With* option to the call running it
+type optionError struct {
+	err error
+}
+
+func fail(err error) {
+	if err != nil {
+		panic(optionError{err: err})
+	}
+}
+
+// apply runs option and returns the error it failed with
+func (p *GamePerson) apply(option Option) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			failure, ok := recovered.(optionError)
+			if !ok {
+				panic(recovered)
+			}
+			err = failure.err
+		}
+	}()
+
+	option(p)
+	return nil
+}
+
+func checkRange(field string, value, limit int) error {
+	if value < 0 || value > limit {
+		return fmt.Errorf("%w: %s %d, expected [0, %d]", ErrOutOfRange, field, value, limit)
+	}
+
+	return nil
+}
+
+func checkInt32(field string, value int) error {
+	if value < math.MinInt32 || value > math.MaxInt32 {
+		return fmt.Errorf("%w: %s %d does not fit into int32", ErrOutOfRange, field, value)
+	}
+
+	return nil
+}
+
+// WithName truncates names longer than 42 bytes at a rune boundary
 func WithName(name string) Option {
 	return func(person *GamePerson) {
-		copy(person.name[:], name)
-		for i := len(name); i < len(person.name); i++ {
-			person.name[i] = 0
-		}
+		fail(person.SetName(name))
+	}
+}
+
+func WithCoordinates(x, y, z int) Option {
+	return func(person *GamePerson) {
+		fail(person.SetCoordinates(x, y, z))
+	}
+}
+
+func WithGold(gold int) Option {
+	return func(person *GamePerson) {
+		fail(person.SetGold(gold))
+	}
+}
+
+func WithMana(mana int) Option {
+	return func(person *GamePerson) {
+		fail(person.SetMana(mana))
 	}
 }
 
-func WithCoordinates(x, y, z int) func(*GamePerson) {
+func WithType(personType int) Option {
 	return func(person *GamePerson) {
-		person.x = int32(x)
-		person.y = int32(y)
-		person.z = int32(z)
+		fail(person.SetType(personType))
 	}
 }
 
-func WithGold(gold int) func(*GamePerson) {
+func WithHealth(health int) Option {
 	return func(person *GamePerson) {
-		person.gold = int32(gold)
+		fail(person.SetHealth(health))
 	}
 }
 
-func WithMana(mana int) func(*GamePerson) {
+func WithHouse() Option {
 	return func(person *GamePerson) {
-		person.manaType |= int16(mana)
+		person.SetHouse(true)
 	}
 }
 
-func WithType(personType int) func(*GamePerson) {
+// WithoutHouse clears the flag, e.g. in Apply
+func WithoutHouse() Option {
 	return func(person *GamePerson) {
-		person.manaType |= int16(personType) << personTypeShift
+		person.SetHouse(false)
 	}
 }
 
-func WithHealth(health int) func(*GamePerson) {
+func WithGun() Option {
 	return func(person *GamePerson) {
-		person.healthHouseGunFamily |= int16(health) << healthShift
+		person.SetGun(true)
 	}
 }
 
-func WithHouse() func(*GamePerson) {
+// WithoutGun clears the flag, e.g. in Apply
+func WithoutGun() Option {
 	return func(person *GamePerson) {
-		person.healthHouseGunFamily |= houseBit
+		person.SetGun(false)
 	}
 }
 
-func WithGun() func(*GamePerson) {
+func WithFamily() Option {
 	return func(person *GamePerson) {
-		person.healthHouseGunFamily |= gunBit
+		person.SetFamily(true)
 	}
 }
 
-func WithFamily() func(*GamePerson) {
+// WithoutFamily clears the flag, e.g. in Apply
+func WithoutFamily() Option {
 	return func(person *GamePerson) {
-		person.healthHouseGunFamily |= familyBit
+		person.SetFamily(false)
 	}
 }
 
-func WithRespect(respect int) func(*GamePerson) {
+func WithRespect(respect int) Option {
 	return func(person *GamePerson) {
-		person.levelExpirienceStrengthRespect |= uint16(respect) << respectShift
+		fail(person.SetRespect(respect))
 	}
 }
 
-func WithStrength(strength int) func(*GamePerson) {
+func WithStrength(strength int) Option {
 	return func(person *GamePerson) {
-		person.levelExpirienceStrengthRespect |= uint16(strength) << strengthShift
+		fail(person.SetStrength(strength))
 	}
 }
 
-func WithExperience(experience int) func(*GamePerson) {
+func WithExperience(experience int) Option {
 	return func(person *GamePerson) {
-		person.levelExpirienceStrengthRespect |= uint16(experience) << experienceShift
+		fail(person.SetExperience(experience))
 	}
 }
 
-func WithLevel(level int) func(*GamePerson) {
+func WithLevel(level int) Option {
 	return func(person *GamePerson) {
-		person.levelExpirienceStrengthRespect |= uint16(level)
+		fail(person.SetLevel(level))
 	}
 }
 
@@ -131,15 +206,183 @@ type GamePerson struct {
 	healthHouseGunFamily           int16
 }
 
+// NewGamePerson does not report errors: invalid numbers are skipped, since
+// writing them would corrupt neighbouring bit fields, and long names are
+// truncated. Use NewGamePersonChecked to get the errors
 func NewGamePerson(options ...Option) GamePerson {
 	person := GamePerson{}
 	for _, option := range options {
-		option(&person)
+		_ = person.apply(option)
 	}
 
 	return person
 }
 
+// NewGamePersonChecked applies all options and returns every violation
+// joined into one error, together with the zero GamePerson
+func NewGamePersonChecked(options ...Option) (GamePerson, error) {
+	person := GamePerson{}
+	if err := person.Apply(options...); err != nil {
+		return GamePerson{}, err
+	}
+
+	return person, nil
+}
+
+// Apply replaces the fields set by options, leaving the others unchanged.
+// Invalid values are skipped and their errors are joined
+func (p *GamePerson) Apply(options ...Option) error {
+	var errs []error
+	for _, option := range options {
+		if err := p.apply(option); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// SetName truncates names longer than 42 bytes at a rune boundary and
+// reports it with ErrNameTooLong
+func (p *GamePerson) SetName(name string) error {
+	var err error
+	if len(name) > len(p.name) {
+		err = fmt.Errorf("%w: %d bytes, expected at most %d", ErrNameTooLong, len(name), len(p.name))
+
+		length := len(p.name)
+		for length > 0 && !utf8.RuneStart(name[length]) {
+			length--
+		}
+		name = name[:length]
+	}
+
+	copy(p.name[:], name)
+	for i := len(name); i < len(p.name); i++ {
+		p.name[i] = 0
+	}
+
+	return err
+}
+
+func (p *GamePerson) SetCoordinates(x, y, z int) error {
+	err := errors.Join(checkInt32("x", x), checkInt32("y", y), checkInt32("z", z))
+	if err != nil {
+		return err
+	}
+
+	p.x = int32(x)
+	p.y = int32(y)
+	p.z = int32(z)
+
+	return nil
+}
+
+func (p *GamePerson) SetGold(gold int) error {
+	if err := checkInt32("gold", gold); err != nil {
+		return err
+	}
+
+	p.gold = int32(gold)
+	return nil
+}
+
+// AddGold adds delta, which may be negative, clamping the result to the
+// int32 range, and returns the new amount
+func (p *GamePerson) AddGold(delta int) int {
+	gold := int64(p.gold) + max(min(int64(delta), 2*math.MaxInt32), 2*math.MinInt32)
+	p.gold = int32(max(min(gold, math.MaxInt32), math.MinInt32))
+
+	return int(p.gold)
+}
+
+func (p *GamePerson) SetMana(mana int) error {
+	if err := checkRange("mana", mana, manaMask); err != nil {
+		return err
+	}
+
+	p.manaType = int16(uint16(p.manaType)&^manaMask | uint16(mana))
+	return nil
+}
+
+func (p *GamePerson) SetType(personType int) error {
+	if err := checkRange("type", personType, personTypeMask>>personTypeShift); err != nil {
+		return err
+	}
+
+	p.manaType = int16(uint16(p.manaType)&^personTypeMask | uint16(personType)<<personTypeShift)
+	return nil
+}
+
+func (p *GamePerson) SetHealth(health int) error {
+	if err := checkRange("health", health, healthMask); err != nil {
+		return err
+	}
+
+	p.healthHouseGunFamily = p.healthHouseGunFamily&^(healthMask<<healthShift) | int16(health)<<healthShift
+	return nil
+}
+
+func (p *GamePerson) SetHouse(hasHouse bool) {
+	p.setFlag(houseBit, hasHouse)
+}
+
+func (p *GamePerson) SetGun(hasGun bool) {
+	p.setFlag(gunBit, hasGun)
+}
+
+func (p *GamePerson) SetFamily(hasFamily bool) {
+	p.setFlag(familyBit, hasFamily)
+}
+
+func (p *GamePerson) setFlag(bit int16, value bool) {
+	if value {
+		p.healthHouseGunFamily |= bit
+	} else {
+		p.healthHouseGunFamily &^= bit
+	}
+}
+
+func (p *GamePerson) SetRespect(respect int) error {
+	if err := checkRange("respect", respect, respectMask); err != nil {
+		return err
+	}
+
+	p.setNibble(respectShift, respect)
+	return nil
+}
+
+func (p *GamePerson) SetStrength(strength int) error {
+	if err := checkRange("strength", strength, strengthMask); err != nil {
+		return err
+	}
+
+	p.setNibble(strengthShift, strength)
+	return nil
+}
+
+func (p *GamePerson) SetExperience(experience int) error {
+	if err := checkRange("experience", experience, experienceMask); err != nil {
+		return err
+	}
+
+	p.setNibble(experienceShift, experience)
+	return nil
+}
+
+func (p *GamePerson) SetLevel(level int) error {
+	if err := checkRange("level", level, levelMask); err != nil {
+		return err
+	}
+
+	p.setNibble(0, level)
+	return nil
+}
+
+// setNibble rewrites one of the 4-bit fields of levelExpirienceStrengthRespect
+func (p *GamePerson) setNibble(shift, value int) {
+	p.levelExpirienceStrengthRespect = p.levelExpirienceStrengthRespect&^(0x000F<<shift) | uint16(value)<<shift
+}
+
 func (p *GamePerson) Name() string {
 	length := 0
 	for i, b := range p.name {
@@ -172,7 +415,7 @@ func (p *GamePerson) Mana() int {
 }
 
 func (p *GamePerson) Type() int {
-	return int(p.manaType >> personTypeShift)
+	return int(uint16(p.manaType) >> personTypeShift)
 }
 
 func (p *GamePerson) Health() int {
@@ -253,3 +496,222 @@ func TestGamePerson(t *testing.T) {
 	assert.False(t, person.HasGun())
 	assert.Equal(t, personType, person.Type())
 }
+
+func TestGamePersonChecked(t *testing.T) {
+	person, err := NewGamePersonChecked(
+		WithName("valid"),
+		WithMana(1023),
+		WithHealth(2047),
+		WithType(63),
+		WithRespect(15),
+		WithStrength(15),
+		WithExperience(15),
+		WithLevel(15),
+		WithGun(),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "valid", person.Name())
+	assert.Equal(t, 1023, person.Mana())
+	assert.Equal(t, 2047, person.Health())
+	assert.Equal(t, 63, person.Type())
+	assert.Equal(t, 15, person.Level())
+	assert.True(t, person.HasGun())
+
+	person, err = NewGamePersonChecked(
+		WithMana(1024),
+		WithHealth(2048),
+		WithType(64),
+		WithRespect(16),
+		WithStrength(-1),
+		WithExperience(16),
+		WithLevel(16),
+		WithGold(math.MaxInt32+1),
+		WithCoordinates(0, math.MinInt32-1, 0),
+		WithHouse(),
+	)
+	assert.ErrorIs(t, err, ErrOutOfRange)
+	for _, field := range []string{"mana", "health", "type", "respect", "strength", "experience", "level", "gold", "y"} {
+		assert.ErrorContains(t, err, field)
+	}
+	joined, ok := err.(interface{ Unwrap() []error })
+	if assert.True(t, ok) {
+		assert.Len(t, joined.Unwrap(), 9)
+	}
+	assert.Equal(t, GamePerson{}, person)
+
+	unchecked := NewGamePerson(WithMana(1024), WithType(1))
+	assert.Zero(t, unchecked.Mana())
+	assert.Equal(t, 1, unchecked.Type())
+
+	// options defined by callers keep working next to the checked ones
+	withTitle := func(person *GamePerson) {
+		person.SetName("sir " + person.Name())
+	}
+	person, err = NewGamePersonChecked(WithName("knight"), withTitle, WithLevel(2))
+	assert.NoError(t, err)
+	assert.Equal(t, "sir knight", person.Name())
+	assert.Equal(t, 2, person.Level())
+}
+
+func TestGamePersonCheckedName(t *testing.T) {
+	// the 42nd byte is the first half of a two-byte rune
+	name := "x" + strings.Repeat("а", 21)
+
+	person, err := NewGamePersonChecked(WithName(name))
+	assert.ErrorIs(t, err, ErrNameTooLong)
+	assert.Equal(t, GamePerson{}, person)
+
+	person = NewGamePerson(WithName(name))
+	assert.Equal(t, name[:41], person.Name())
+	assert.True(t, utf8.ValidString(person.Name()))
+
+	person, err = NewGamePersonChecked(WithName(name[1:]))
+	assert.NoError(t, err)
+	assert.Equal(t, name[1:], person.Name())
+
+	err = person.Apply(WithName(strings.Repeat("x", 43)))
+	assert.ErrorIs(t, err, ErrNameTooLong)
+	assert.Equal(t, strings.Repeat("x", 42), person.Name())
+}
+
+func TestGamePersonSetters(t *testing.T) {
+	person := NewGamePerson(
+		WithName("first"),
+		WithMana(1000),
+		WithHealth(1000),
+		WithType(WarriorGamePersonType),
+		WithRespect(10),
+		WithStrength(10),
+		WithExperience(10),
+		WithLevel(10),
+		WithHouse(),
+		WithGun(),
+	)
+
+	assert.NoError(t, person.SetMana(23))
+	assert.NoError(t, person.SetHealth(2047))
+	assert.NoError(t, person.SetType(63))
+	assert.NoError(t, person.SetLevel(5))
+	assert.NoError(t, person.SetStrength(0))
+	assert.NoError(t, person.SetName("second"))
+	person.SetHouse(false)
+	person.SetFamily(true)
+
+	assert.Equal(t, "second", person.Name())
+	assert.Equal(t, 23, person.Mana())
+	assert.Equal(t, 2047, person.Health())
+	assert.Equal(t, 63, person.Type())
+	assert.Equal(t, 5, person.Level())
+	assert.Equal(t, 0, person.Strength())
+	assert.Equal(t, 10, person.Respect())
+	assert.Equal(t, 10, person.Experience())
+	assert.False(t, person.HasHouse())
+	assert.True(t, person.HasGun())
+	assert.True(t, person.HasFamilty())
+
+	assert.ErrorIs(t, person.SetMana(1024), ErrOutOfRange)
+	assert.ErrorIs(t, person.SetLevel(16), ErrOutOfRange)
+	assert.Equal(t, 23, person.Mana())
+	assert.Equal(t, 63, person.Type())
+	assert.Equal(t, 5, person.Level())
+	assert.Equal(t, 0, person.Strength())
+
+	assert.NoError(t, person.SetType(0))
+	assert.Equal(t, 0, person.Type())
+	assert.Equal(t, 23, person.Mana())
+	assert.NoError(t, person.SetHealth(0))
+	assert.True(t, person.HasGun())
+	assert.True(t, person.HasFamilty())
+}
+
+func TestGamePersonAddGold(t *testing.T) {
+	person := NewGamePerson(WithGold(100))
+
+	assert.Equal(t, 150, person.AddGold(50))
+	assert.Equal(t, 100, person.AddGold(-50))
+	assert.Equal(t, math.MaxInt32, person.AddGold(math.MaxInt32))
+	assert.Equal(t, math.MaxInt32, person.AddGold(math.MaxInt))
+	assert.Equal(t, -1, person.AddGold(math.MinInt32))
+	assert.Equal(t, math.MinInt32, person.AddGold(math.MinInt32))
+	assert.Equal(t, math.MinInt32, person.AddGold(math.MinInt))
+	assert.Equal(t, math.MinInt32, person.Gold())
+}
+
+func TestGamePersonApply(t *testing.T) {
+	person := NewGamePerson(WithMana(512), WithHealth(1024), WithLevel(3), WithType(1))
+
+	err := person.Apply(WithMana(511), WithHealth(1023), WithLevel(12), WithCoordinates(1, 2, 3))
+	assert.NoError(t, err)
+	assert.Equal(t, 511, person.Mana())
+	assert.Equal(t, 1023, person.Health())
+	assert.Equal(t, 12, person.Level())
+	assert.Equal(t, 1, person.Type())
+	assert.Equal(t, 2, person.Y())
+
+	err = person.Apply(WithMana(2000), WithLevel(1))
+	assert.ErrorIs(t, err, ErrOutOfRange)
+	assert.Equal(t, 511, person.Mana())
+	assert.Equal(t, 1, person.Level())
+
+	again := NewGamePerson(WithMana(1), WithMana(2))
+	assert.Equal(t, 2, again.Mana())
+
+	flags := NewGamePerson(WithHouse(), WithGun(), WithFamily(), WithHealth(100))
+	assert.NoError(t, flags.Apply(WithoutHouse(), WithoutFamily()))
+	assert.False(t, flags.HasHouse())
+	assert.True(t, flags.HasGun())
+	assert.False(t, flags.HasFamilty())
+	assert.Equal(t, 100, flags.Health())
+
+	assert.NoError(t, flags.Apply(WithoutGun(), WithHouse()))
+	assert.True(t, flags.HasHouse())
+	assert.False(t, flags.HasGun())
+}
+
+func TestGamePersonApplyNested(t *testing.T) {
+	_, err := NewGamePersonChecked(func(person *GamePerson) {
+		assert.NoError(t, person.Apply(WithLevel(1)))
+	}, WithMana(5000))
+	assert.ErrorIs(t, err, ErrOutOfRange)
+
+	var inner error
+	person, err := NewGamePersonChecked(func(person *GamePerson) {
+		inner = person.Apply(WithLevel(100), WithHealth(5))
+	}, WithMana(5))
+	assert.NoError(t, err)
+	assert.ErrorIs(t, inner, ErrOutOfRange)
+	assert.Equal(t, 5, person.Mana())
+	assert.Equal(t, 5, person.Health())
+
+	// a failing option ends the option that called it
+	person = NewGamePerson(func(person *GamePerson) {
+		WithMana(5000)(person)
+		WithMana(7)(person)
+	}, WithLevel(2))
+	assert.Equal(t, 0, person.Mana())
+	assert.Equal(t, 2, person.Level())
+
+	assert.PanicsWithValue(t, "boom", func() {
+		_ = person.Apply(func(*GamePerson) { panic("boom") })
+	})
+}
+
+func TestGamePersonApplyConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				person, err := NewGamePersonChecked(WithMana(i), WithLevel(i+j%2*100))
+				if j%2 == 0 {
+					assert.NoError(t, err)
+					assert.Equal(t, i, person.Level())
+				} else {
+					assert.ErrorIs(t, err, ErrOutOfRange)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}